@@ -0,0 +1,373 @@
+package azkube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/util"
+)
+
+const (
+	DefaultAutoscaleInterval             = 30 * time.Second
+	DefaultAutoscaleScaleUpCooldown      = 3 * time.Minute
+	DefaultAutoscaleScaleDownCooldown    = 10 * time.Minute
+	DefaultAutoscaleScaleDownUtilization = 0.5
+)
+
+// NodeObserver reports the scheduling state of a node pool. The cluster's
+// apiserver provides it in practice; see util.KubeNodeObserver.
+type NodeObserver interface {
+	// PendingPods returns the requests of pods that could not be scheduled
+	// and would run on the pool.
+	PendingPods() ([]util.ResourceRequest, error)
+
+	// PoolNodes returns the usage of the pool's Ready nodes.
+	PoolNodes() ([]util.NodeUsage, error)
+}
+
+// PoolScaler reads and changes the capacity of a node pool.
+type PoolScaler interface {
+	Capacity() (int, error)
+	ScaleTo(ctx context.Context, count int) error
+
+	// RemoveNode drains and removes one node, lowering the capacity by one.
+	RemoveNode(ctx context.Context, nodeName string) error
+}
+
+// AutoscalePolicy bounds what the autoscaler may do.
+type AutoscalePolicy struct {
+	MinNodes int
+	MaxNodes int
+
+	// ScaleUpCooldown is the time to wait after any change before adding
+	// nodes. ScaleDownCooldown is the time to wait before removing one.
+	ScaleUpCooldown   time.Duration
+	ScaleDownCooldown time.Duration
+
+	// ScaleDownUtilization is the fraction of requested cpu or memory below
+	// which a node is considered underutilized.
+	ScaleDownUtilization float64
+}
+
+func (policy AutoscalePolicy) Validate() error {
+	if policy.MinNodes < 0 {
+		return &SpecError{Field: "MinNodes", Message: "must not be negative"}
+	}
+	if policy.MaxNodes < policy.MinNodes || policy.MaxNodes == 0 {
+		return &SpecError{Field: "MaxNodes", Message: "must be positive and at least MinNodes"}
+	}
+	if policy.ScaleDownUtilization < 0 || policy.ScaleDownUtilization > 1 {
+		return &SpecError{Field: "ScaleDownUtilization", Message: "must be between 0 and 1"}
+	}
+	return nil
+}
+
+// AutoscaleDecision is the capacity the autoscaler wants and why. When it
+// scales down because of an underutilized node, RemoveNode names the node.
+type AutoscaleDecision struct {
+	Capacity   int
+	Target     int
+	Reason     string
+	RemoveNode string
+}
+
+func (decision AutoscaleDecision) Changed() bool {
+	return decision.Target != decision.Capacity
+}
+
+// Autoscaler grows a node pool while pods are unschedulable and shrinks it,
+// one node at a time, while nodes are underutilized.
+type Autoscaler struct {
+	Policy   AutoscalePolicy
+	Observer NodeObserver
+	Scaler   PoolScaler
+
+	// Now is the clock used for cooldowns. It defaults to time.Now.
+	Now func() time.Time
+
+	lastScaleUp   time.Time
+	lastScaleDown time.Time
+}
+
+func NewAutoscaler(policy AutoscalePolicy, observer NodeObserver, scaler PoolScaler) *Autoscaler {
+	return &Autoscaler{
+		Policy:   policy,
+		Observer: observer,
+		Scaler:   scaler,
+		Now:      time.Now,
+	}
+}
+
+// Decide observes the pool and returns the capacity it should have now. It
+// does not change anything.
+func (a *Autoscaler) Decide() (AutoscaleDecision, error) {
+	policy := a.Policy
+	now := a.Now()
+
+	capacity, err := a.Scaler.Capacity()
+	if err != nil {
+		return AutoscaleDecision{}, err
+	}
+	decision := AutoscaleDecision{Capacity: capacity, Target: capacity}
+
+	if capacity < policy.MinNodes {
+		decision.Target, decision.Reason = policy.MinNodes, "below the minimum node count"
+		return decision, nil
+	}
+	if capacity > policy.MaxNodes {
+		decision.Target, decision.Reason = policy.MaxNodes, "above the maximum node count"
+		return decision, nil
+	}
+
+	pending, err := a.Observer.PendingPods()
+	if err != nil {
+		return AutoscaleDecision{}, err
+	}
+	nodes, err := a.Observer.PoolNodes()
+	if err != nil {
+		return AutoscaleDecision{}, err
+	}
+
+	if len(pending) > 0 {
+		if capacity >= policy.MaxNodes {
+			decision.Reason = fmt.Sprintf("%d pod(s) pending, but already at the maximum node count", len(pending))
+			return decision, nil
+		}
+		if a.coolingDown(now, policy.ScaleUpCooldown) {
+			decision.Reason = fmt.Sprintf("%d pod(s) pending, waiting for the scale up cooldown", len(pending))
+			return decision, nil
+		}
+
+		target := capacity + nodesNeeded(pending, nodes)
+		if target > policy.MaxNodes {
+			target = policy.MaxNodes
+		}
+		decision.Target, decision.Reason = target, fmt.Sprintf("%d pod(s) pending", len(pending))
+		return decision, nil
+	}
+
+	if capacity <= policy.MinNodes || len(nodes) < capacity {
+		// nodes that aren't Ready yet may be about to take pods
+		return decision, nil
+	}
+
+	underutilized := 0
+	var leastUtilized *util.NodeUsage
+	var requested, allocatable util.ResourceRequest
+	for i, node := range nodes {
+		if node.Utilization() < policy.ScaleDownUtilization {
+			underutilized++
+			if leastUtilized == nil || node.Utilization() < leastUtilized.Utilization() {
+				leastUtilized = &nodes[i]
+			}
+		}
+		requested = requested.Add(node.Requested)
+		allocatable = allocatable.Add(node.Allocatable)
+	}
+	if underutilized == 0 {
+		return decision, nil
+	}
+
+	if a.coolingDown(now, policy.ScaleDownCooldown) {
+		decision.Reason = fmt.Sprintf("%d node(s) underutilized, waiting for the scale down cooldown", underutilized)
+		return decision, nil
+	}
+
+	// only remove the node if its pods fit on the rest
+	remaining := util.ResourceRequest{
+		MilliCPU: allocatable.MilliCPU - leastUtilized.Allocatable.MilliCPU,
+		Memory:   allocatable.Memory - leastUtilized.Allocatable.Memory,
+	}
+	if requested.MilliCPU > remaining.MilliCPU || requested.Memory > remaining.Memory {
+		decision.Reason = fmt.Sprintf("%d node(s) underutilized, but their pods would not fit on the remaining nodes", underutilized)
+		return decision, nil
+	}
+
+	decision.Target, decision.Reason = capacity-1, fmt.Sprintf("%d node(s) underutilized", underutilized)
+	decision.RemoveNode = leastUtilized.Name
+	return decision, nil
+}
+
+// Step makes one decision and applies it.
+func (a *Autoscaler) Step(ctx context.Context) (AutoscaleDecision, error) {
+	decision, err := a.Decide()
+	if err != nil {
+		return decision, err
+	}
+	if decision.Reason != "" {
+		log.Infof("autoscale: %s. capacity=%d target=%d", decision.Reason, decision.Capacity, decision.Target)
+	}
+	if !decision.Changed() {
+		return decision, nil
+	}
+
+	if decision.RemoveNode != "" {
+		err = a.Scaler.RemoveNode(ctx, decision.RemoveNode)
+	} else {
+		err = a.Scaler.ScaleTo(ctx, decision.Target)
+	}
+	if err != nil {
+		// retry on the next step instead of waiting out the cooldown
+		return decision, err
+	}
+	if decision.Target > decision.Capacity {
+		a.lastScaleUp = a.Now()
+	} else {
+		a.lastScaleDown = a.Now()
+	}
+	return decision, nil
+}
+
+// Run steps every interval until ctx is done. Errors are logged and retried
+// on the next step.
+func (a *Autoscaler) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := a.Step(ctx)
+		if err != nil {
+			log.Warnf("autoscale: step failed (will retry): %q", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Autoscaler) coolingDown(now time.Time, cooldown time.Duration) bool {
+	return now.Sub(a.lastScaleUp) < cooldown || now.Sub(a.lastScaleDown) < cooldown
+}
+
+// nodesNeeded estimates how many nodes like the pool's current ones would
+// hold the pending pods, and is always at least one.
+func nodesNeeded(pending []util.ResourceRequest, nodes []util.NodeUsage) int {
+	var requested, allocatable util.ResourceRequest
+	for _, request := range pending {
+		requested = requested.Add(request)
+	}
+	for _, node := range nodes {
+		allocatable = allocatable.Add(node.Allocatable)
+	}
+	perNode := averageRequest(allocatable, len(nodes))
+
+	needed := 1
+	if perNode.MilliCPU > 0 {
+		needed = maxInt(needed, int((requested.MilliCPU+perNode.MilliCPU-1)/perNode.MilliCPU))
+	}
+	if perNode.Memory > 0 {
+		needed = maxInt(needed, int((requested.Memory+perNode.Memory-1)/perNode.Memory))
+	}
+	return needed
+}
+
+func averageRequest(total util.ResourceRequest, count int) util.ResourceRequest {
+	if count == 0 {
+		return util.ResourceRequest{}
+	}
+	return util.ResourceRequest{MilliCPU: total.MilliCPU / int64(count), Memory: total.Memory / int64(count)}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// AutoscaleSpec describes a node pool to autoscale. The manifest is required
+// to reach the cluster's apiserver.
+type AutoscaleSpec struct {
+	OutputDirectory string
+	Manifest        *util.DeploymentManifest
+	Pool            string
+	Policy          AutoscalePolicy
+
+	// SkipDrain removes nodes without draining them first.
+	SkipDrain bool
+}
+
+// NewAutoscaler returns an autoscaler for a pool of a deployed cluster,
+// observing it through its apiserver and scaling it with Scale.
+func (d *Deployer) NewAutoscaler(spec AutoscaleSpec) (*Autoscaler, error) {
+	manifest := spec.Manifest
+	if manifest == nil {
+		return nil, &SpecError{Field: "Manifest", Message: "is required to autoscale"}
+	}
+	err := spec.Policy.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	pool := spec.Pool
+	if pool == "" {
+		pool = util.DefaultNodePoolName
+	}
+	i, ok := util.FindNodePool(manifest.NodePools, pool)
+	if !ok {
+		return nil, &SpecError{Field: "Pool", Message: fmt.Sprintf("deployment has no node pool %q", pool)}
+	}
+
+	flavorArgs, err := clusterFlavorArgs(manifest, spec.OutputDirectory)
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := util.NewKubernetesClient(flavorArgs.MasterFQDN, flavorArgs.CAKeyPair, flavorArgs.ClientKeyPair)
+	if err != nil {
+		return nil, err
+	}
+
+	poolLabels := map[string]string{util.NodePoolLabel: pool}
+	for key, value := range manifest.NodePools[i].Labels {
+		poolLabels[key] = value
+	}
+
+	observer := &util.KubeNodeObserver{
+		Client:         kubeClient,
+		NodeNamePrefix: util.NodeNamePrefix(manifest.DeploymentName, pool),
+		PoolLabels:     poolLabels,
+	}
+	scaler := &deployerPoolScaler{
+		d: d,
+		spec: ScaleSpec{
+			OutputDirectory: spec.OutputDirectory,
+			Manifest:        manifest,
+			DeploymentName:  manifest.DeploymentName,
+			ResourceGroup:   manifest.ResourceGroup,
+			Pool:            pool,
+			SkipDrain:       spec.SkipDrain,
+		},
+	}
+
+	return NewAutoscaler(spec.Policy, observer, scaler), nil
+}
+
+// deployerPoolScaler scales a pool through the same path as the scale
+// command, including draining and waiting for Ready nodes.
+type deployerPoolScaler struct {
+	d    *Deployer
+	spec ScaleSpec
+}
+
+func (s *deployerPoolScaler) Capacity() (int, error) {
+	_, capacity, err := s.d.Client.ScaleSetSku(s.spec.ResourceGroup, util.NodeScaleSetName(s.spec.DeploymentName, s.spec.Pool))
+	return capacity, err
+}
+
+func (s *deployerPoolScaler) ScaleTo(ctx context.Context, count int) error {
+	spec := s.spec
+	spec.NodeCount = count
+	return s.d.Scale(ctx, spec)
+}
+
+func (s *deployerPoolScaler) RemoveNode(ctx context.Context, nodeName string) error {
+	spec := s.spec
+	spec.NodeCount, spec.RelativeNodeCount = -1, true
+	spec.RemoveNodes = []string{nodeName}
+	return s.d.Scale(ctx, spec)
+}
@@ -0,0 +1,223 @@
+package azkube
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/colemickens/azkube/util"
+)
+
+type fakeNodeObserver struct {
+	pending []util.ResourceRequest
+	nodes   []util.NodeUsage
+}
+
+func (o *fakeNodeObserver) PendingPods() ([]util.ResourceRequest, error) {
+	return o.pending, nil
+}
+
+func (o *fakeNodeObserver) PoolNodes() ([]util.NodeUsage, error) {
+	return o.nodes, nil
+}
+
+type fakePoolScaler struct {
+	capacity int
+	scaledTo []int
+	removed  []string
+
+	// err fails ScaleTo and RemoveNode without changing the capacity
+	err error
+}
+
+func (s *fakePoolScaler) Capacity() (int, error) {
+	return s.capacity, nil
+}
+
+func (s *fakePoolScaler) ScaleTo(ctx context.Context, count int) error {
+	s.scaledTo = append(s.scaledTo, count)
+	if s.err != nil {
+		return s.err
+	}
+	s.capacity = count
+	return nil
+}
+
+func (s *fakePoolScaler) RemoveNode(ctx context.Context, nodeName string) error {
+	s.removed = append(s.removed, nodeName)
+	if s.err != nil {
+		return s.err
+	}
+	s.capacity--
+	return nil
+}
+
+var testAutoscalePolicy = AutoscalePolicy{
+	MinNodes:             1,
+	MaxNodes:             5,
+	ScaleUpCooldown:      DefaultAutoscaleScaleUpCooldown,
+	ScaleDownCooldown:    DefaultAutoscaleScaleDownCooldown,
+	ScaleDownUtilization: DefaultAutoscaleScaleDownUtilization,
+}
+
+func testNode(name string, requestedMilliCPU int64) util.NodeUsage {
+	return util.NodeUsage{
+		Name:        name,
+		Requested:   util.ResourceRequest{MilliCPU: requestedMilliCPU, Memory: requestedMilliCPU << 20},
+		Allocatable: util.ResourceRequest{MilliCPU: 1000, Memory: 1000 << 20},
+	}
+}
+
+func TestAutoscalerDecide(t *testing.T) {
+	now := time.Date(2016, 8, 1, 12, 0, 0, 0, time.UTC)
+	pod := util.ResourceRequest{MilliCPU: 500, Memory: 500 << 20}
+
+	tests := []struct {
+		name          string
+		capacity      int
+		pending       []util.ResourceRequest
+		nodes         []util.NodeUsage
+		lastScaleUp   time.Time
+		lastScaleDown time.Time
+
+		target     int
+		removeNode string
+	}{
+		{
+			name:     "below the minimum",
+			capacity: 0,
+			target:   1,
+		},
+		{
+			name:     "above the maximum",
+			capacity: 7,
+			target:   5,
+		},
+		{
+			name:     "pending pods",
+			capacity: 2,
+			pending:  []util.ResourceRequest{pod, pod, pod},
+			nodes:    []util.NodeUsage{testNode("n0", 900), testNode("n1", 900)},
+			target:   4,
+		},
+		{
+			name:     "pending pods capped at the maximum",
+			capacity: 4,
+			pending:  []util.ResourceRequest{pod, pod, pod, pod, pod},
+			nodes:    []util.NodeUsage{testNode("n0", 900), testNode("n1", 900), testNode("n2", 900), testNode("n3", 900)},
+			target:   5,
+		},
+		{
+			name:     "pending pods at the maximum",
+			capacity: 5,
+			pending:  []util.ResourceRequest{pod},
+			nodes:    []util.NodeUsage{testNode("n0", 900), testNode("n1", 900), testNode("n2", 900), testNode("n3", 900), testNode("n4", 900)},
+			target:   5,
+		},
+		{
+			name:        "pending pods during the scale up cooldown",
+			capacity:    2,
+			pending:     []util.ResourceRequest{pod},
+			nodes:       []util.NodeUsage{testNode("n0", 900), testNode("n1", 900)},
+			lastScaleUp: now.Add(-time.Minute),
+			target:      2,
+		},
+		{
+			name:       "underutilized node",
+			capacity:   3,
+			nodes:      []util.NodeUsage{testNode("n0", 600), testNode("n1", 100), testNode("n2", 300)},
+			target:     2,
+			removeNode: "n1",
+		},
+		{
+			name:     "underutilized node whose pods would not fit",
+			capacity: 2,
+			nodes:    []util.NodeUsage{testNode("n0", 900), testNode("n1", 400)},
+			target:   2,
+		},
+		{
+			name:          "underutilized node during the scale down cooldown",
+			capacity:      3,
+			nodes:         []util.NodeUsage{testNode("n0", 600), testNode("n1", 100), testNode("n2", 300)},
+			lastScaleDown: now.Add(-time.Minute),
+			target:        3,
+		},
+		{
+			name:     "underutilized node at the minimum",
+			capacity: 1,
+			nodes:    []util.NodeUsage{testNode("n0", 100)},
+			target:   1,
+		},
+		{
+			name:     "nodes not ready yet",
+			capacity: 3,
+			nodes:    []util.NodeUsage{testNode("n0", 100), testNode("n1", 100)},
+			target:   3,
+		},
+	}
+
+	for _, test := range tests {
+		a := NewAutoscaler(testAutoscalePolicy,
+			&fakeNodeObserver{pending: test.pending, nodes: test.nodes},
+			&fakePoolScaler{capacity: test.capacity})
+		a.Now = func() time.Time { return now }
+		a.lastScaleUp, a.lastScaleDown = test.lastScaleUp, test.lastScaleDown
+
+		decision, err := a.Decide()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if decision.Capacity != test.capacity || decision.Target != test.target {
+			t.Errorf("%s: got capacity=%d target=%d, want capacity=%d target=%d", test.name, decision.Capacity, decision.Target, test.capacity, test.target)
+		}
+		if decision.RemoveNode != test.removeNode {
+			t.Errorf("%s: got RemoveNode=%q, want %q", test.name, decision.RemoveNode, test.removeNode)
+		}
+	}
+}
+
+func TestAutoscalerStepRemovesChosenNode(t *testing.T) {
+	now := time.Date(2016, 8, 1, 12, 0, 0, 0, time.UTC)
+	scaler := &fakePoolScaler{capacity: 3}
+	a := NewAutoscaler(testAutoscalePolicy,
+		&fakeNodeObserver{nodes: []util.NodeUsage{testNode("n0", 600), testNode("n1", 100), testNode("n2", 300)}},
+		scaler)
+	a.Now = func() time.Time { return now }
+
+	_, err := a.Step(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(scaler.scaledTo) != 0 {
+		t.Errorf("got ScaleTo%v, want no calls", scaler.scaledTo)
+	}
+	if len(scaler.removed) != 1 || scaler.removed[0] != "n1" {
+		t.Errorf("got RemoveNode%v, want [n1]", scaler.removed)
+	}
+}
+
+func TestAutoscalerStepRetriesFailedScale(t *testing.T) {
+	now := time.Date(2016, 8, 1, 12, 0, 0, 0, time.UTC)
+	scaler := &fakePoolScaler{capacity: 2, err: errors.New("throttled")}
+	pod := util.ResourceRequest{MilliCPU: 500, Memory: 500 << 20}
+	a := NewAutoscaler(testAutoscalePolicy,
+		&fakeNodeObserver{pending: []util.ResourceRequest{pod}, nodes: []util.NodeUsage{testNode("n0", 900), testNode("n1", 900)}},
+		scaler)
+	a.Now = func() time.Time { return now }
+
+	_, err := a.Step(context.Background())
+	if err == nil {
+		t.Fatalf("expected the failed scale up to be returned")
+	}
+
+	scaler.err = nil
+	decision, err := a.Step(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Target != 3 || scaler.capacity != 3 {
+		t.Errorf("got target=%d capacity=%d, want the scale up retried without waiting for the cooldown", decision.Target, scaler.capacity)
+	}
+}
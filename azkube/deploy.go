@@ -0,0 +1,511 @@
+package azkube
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/util"
+)
+
+const (
+	DefaultKubernetesHyperkubeSpec = "gcr.io/google_containers/hyperkube-amd64:v1.3.0"
+)
+
+// DeploySpec describes a cluster to deploy. Empty DeploymentName,
+// ResourceGroup, MasterFQDN and OutputDirectory are derived automatically.
+type DeploySpec struct {
+	OutputDirectory         string
+	DeploymentName          string
+	ResourceGroup           string
+	Location                string
+	MasterSize              string
+	NodePools               []util.NodePool
+	KubernetesHyperkubeSpec string
+	Username                string
+	MasterFQDN              string
+	MasterPrivateIP         net.IP
+	ClusterDomain           string
+	MasterExtraFQDNs        []string
+
+	// ServicePrincipalPassthrough hands the cluster the credentials in
+	// ServicePrincipalClientID and ServicePrincipalClientSecret instead of
+	// creating a new service principal.
+	ServicePrincipalPassthrough  bool
+	ServicePrincipalClientID     string
+	ServicePrincipalClientSecret string
+	NoCloudProvider              bool
+
+	// Protect puts a CanNotDelete management lock on the resource group once
+	// the cluster is up, so that destroy refuses to delete it.
+	Protect bool
+
+	// RollbackOnFailure deletes the resources this deployment created if it
+	// fails. Resources that existed beforehand are never touched.
+	RollbackOnFailure bool
+}
+
+// RenderSpec describes a deployment to render without touching Azure. The
+// tenant and service principal values are rendered as given.
+type RenderSpec struct {
+	DeploySpec
+
+	SubscriptionID string
+	TenantID       string
+	Environment    util.Environment
+}
+
+// Deploy creates the cluster described by spec. If the output directory
+// already holds a manifest, the deployment it describes is resumed instead
+// and spec only contributes its output directory and passthrough credentials.
+func (d *Deployer) Deploy(ctx context.Context, spec DeploySpec) (*util.DeploymentManifest, error) {
+	azureClient := d.Client
+
+	err := spec.Complete(azureClient.Environment)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := d.loadResumableManifest(&spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.ServicePrincipalPassthrough && (spec.ServicePrincipalClientID == "" || spec.ServicePrincipalClientSecret == "") {
+		return nil, &SpecError{Field: "ServicePrincipalClientSecret", Message: "service principal passthrough requires client credentials"}
+	}
+
+	err = d.saveManifest(ctx, "deploy", manifest, spec.OutputDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.deploy(ctx, spec, manifest)
+	if err != nil {
+		saveErr := util.SaveManifest(spec.OutputDirectory, manifest)
+		if saveErr != nil {
+			log.Warnf("Failed to save the deployment manifest: %q", saveErr)
+		}
+
+		if spec.RollbackOnFailure && len(manifest.Journal) > 0 {
+			log.Warnf("Deployment failed. Rolling back the resources it created.")
+			// the deploy context may be what failed, so give the rollback its own
+			rollbackErr := d.Rollback(context.Background(), manifest, spec.OutputDirectory)
+			if rollbackErr != nil {
+				log.Errorf("Rollback did not complete: %q", rollbackErr)
+			}
+		}
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func (d *Deployer) deploy(ctx context.Context, spec DeploySpec, manifest *util.DeploymentManifest) error {
+	azureClient := d.Client
+	outputDirectory := spec.OutputDirectory
+
+	if !manifest.StepCompleted(util.StepArmDeployment) {
+		err := d.preflightDeploy(ctx, spec, manifest)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := d.runStep(ctx, "deploy", manifest, outputDirectory, util.StepResourceGroup, func() error {
+		exists, err := azureClient.ResourceGroupExists(spec.ResourceGroup)
+		if err != nil {
+			return err
+		}
+		_, err = azureClient.EnsureResourceGroup(spec.ResourceGroup, spec.Location)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			manifest.RecordCreated(util.JournalResourceGroup, spec.ResourceGroup)
+		}
+		return azureClient.TagResourceGroup(spec.ResourceGroup, util.ClusterTags(manifest, azureClient.Creator()))
+	})
+	if err != nil {
+		return err
+	}
+
+	err = d.runStep(ctx, "deploy", manifest, outputDirectory, util.StepServicePrincipal, func() error {
+		return d.ensureCloudProviderCredentials(spec, manifest)
+	})
+	if err != nil {
+		return err
+	}
+
+	if !spec.NoCloudProvider && !spec.ServicePrincipalPassthrough {
+		err = d.runStep(ctx, "deploy", manifest, outputDirectory, util.StepRoleAssignment, func() error {
+			roleAssignmentID, err := azureClient.CreateRoleAssignment(spec.ResourceGroup, manifest.ServicePrincipalClientID, ctx.Done())
+			if err != nil {
+				return err
+			}
+			if roleAssignmentID != "" {
+				manifest.RecordCreated(util.JournalRoleAssignment, roleAssignmentID)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	flavorArgs, err := loadOrCreateFlavorArgs(spec, azureClient.Environment, azureClient.TenantID, manifest.ServicePrincipalClientID, manifest.ServicePrincipalClientSecret)
+	if err != nil {
+		return stepError(ctx, "deploy", "assets", err)
+	}
+	flavorArgs.Tags = util.ClusterTags(manifest, azureClient.Creator())
+
+	err = d.runStep(ctx, "deploy", manifest, outputDirectory, util.StepArmDeployment, func() error {
+		return azureClient.DeployFlavor(manifest.Flavor, flavorArgs, outputDirectory, ctx.Done())
+	})
+	if err != nil {
+		return err
+	}
+
+	err = util.ValidateKubernetes(flavorArgs, ctx.Done())
+	if err != nil {
+		return stepError(ctx, "deploy", util.StepValidation, err)
+	}
+	manifest.CompleteStep(util.StepValidation)
+
+	if spec.Protect {
+		err = azureClient.ProtectResourceGroup(spec.ResourceGroup, spec.DeploymentName)
+		if err != nil {
+			return stepError(ctx, "deploy", "protect", err)
+		}
+		manifest.Protected = true
+	}
+	return d.saveManifest(ctx, "deploy", manifest, outputDirectory)
+}
+
+// Render writes the templates, parameters, keys and manifest for a
+// deployment to its output directory without contacting Azure.
+func Render(spec RenderSpec) (*util.DeploymentManifest, error) {
+	err := spec.Complete(spec.Environment)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := util.ManifestExists(spec.OutputDirectory)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		existing, err := util.LoadManifest(spec.OutputDirectory)
+		if err != nil {
+			return nil, err
+		}
+		if len(existing.CompletedSteps) > 0 {
+			return nil, &SpecError{Field: "OutputDirectory", Message: fmt.Sprintf("%q belongs to a deployment that has already started", spec.OutputDirectory)}
+		}
+	}
+
+	spClientID, spClientSecret := spec.ServicePrincipalClientID, spec.ServicePrincipalClientSecret
+	if spec.NoCloudProvider {
+		spClientID, spClientSecret = "", ""
+	}
+
+	flavorArgs, err := loadOrCreateFlavorArgs(spec.DeploySpec, spec.Environment, spec.TenantID, spClientID, spClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := newManifest(spec.SubscriptionID, spec.Environment, spec.TenantID, spec.DeploySpec)
+	manifest.ServicePrincipalClientID = spClientID
+	flavorArgs.Tags = util.ClusterTags(manifest, "")
+	err = util.SaveManifest(spec.OutputDirectory, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, err = util.RenderFlavor(manifest.Flavor, flavorArgs, spec.OutputDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// Complete fills in the fields that are derived from the rest of the spec and
+// creates the output directory. Deploy and Render call it themselves.
+func (spec *DeploySpec) Complete(environment util.Environment) error {
+	if spec.DeploymentName == "" {
+		spec.DeploymentName = fmt.Sprintf("kube-%s", time.Now().Format("20060102-150405"))
+		log.Warnf("deployment name is unset. Generated one: %q", spec.DeploymentName)
+	}
+
+	if spec.ResourceGroup == "" {
+		spec.ResourceGroup = spec.DeploymentName
+		log.Warnf("resource group is unset. Derived one from the deployment name: %q", spec.ResourceGroup)
+	}
+
+	if spec.MasterFQDN == "" {
+		spec.MasterFQDN = fmt.Sprintf("%s.%s.%s", spec.DeploymentName, spec.Location, environment.CloudAppDNSSuffix)
+		log.Warnf("master fqdn is unset. Derived one from input: %q.", spec.MasterFQDN)
+	}
+
+	if spec.KubernetesHyperkubeSpec == "" {
+		spec.KubernetesHyperkubeSpec = DefaultKubernetesHyperkubeSpec
+	}
+
+	if spec.MasterPrivateIP == nil {
+		return &SpecError{Field: "MasterPrivateIP", Message: "must be set"}
+	}
+
+	err := util.ValidateNodePools(spec.NodePools)
+	if err == nil {
+		err = util.ValidateNodePoolTaints(spec.NodePools, spec.KubernetesHyperkubeSpec)
+	}
+	if err != nil {
+		return &SpecError{Field: "NodePools", Message: err.Error()}
+	}
+
+	if spec.OutputDirectory == "" {
+		outputDirectory, err := DefaultOutputDirectory(spec.DeploymentName)
+		if err != nil {
+			return err
+		}
+
+		spec.OutputDirectory = outputDirectory
+		log.Warnf("output directory is unset. Using this location: %q.", spec.OutputDirectory)
+	}
+
+	err = os.MkdirAll(spec.OutputDirectory, 0700)
+	if err != nil {
+		return fmt.Errorf("unable to create output directory for deployment: %q", err)
+	}
+
+	return nil
+}
+
+func (d *Deployer) loadResumableManifest(spec *DeploySpec) (*util.DeploymentManifest, error) {
+	azureClient := d.Client
+
+	exists, err := util.ManifestExists(spec.OutputDirectory)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return newManifest(azureClient.SubscriptionID, azureClient.Environment, azureClient.TenantID, *spec), nil
+	}
+
+	manifest, err := util.LoadManifest(spec.OutputDirectory)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.SubscriptionID != "" && manifest.SubscriptionID != azureClient.SubscriptionID {
+		return nil, ErrDeploymentMismatch
+	}
+	if manifest.Environment != nil && manifest.Environment.Name != azureClient.Environment.Name {
+		return nil, ErrDeploymentMismatch
+	}
+
+	log.Warnf("Found an existing deployment manifest. Resuming deployment %q from %q.", manifest.DeploymentName, spec.OutputDirectory)
+	resumed, err := specFromManifest(manifest, spec.OutputDirectory)
+	if err != nil {
+		return nil, err
+	}
+	resumed.ServicePrincipalClientID = spec.ServicePrincipalClientID
+	resumed.ServicePrincipalClientSecret = spec.ServicePrincipalClientSecret
+	resumed.RollbackOnFailure = spec.RollbackOnFailure
+	resumed.Protect = spec.Protect || manifest.Protected
+	*spec = resumed
+
+	manifest.SubscriptionID = azureClient.SubscriptionID
+	manifest.TenantID = azureClient.TenantID
+	manifest.Environment = &azureClient.Environment
+	if manifest.Created.IsZero() {
+		manifest.Created = time.Now().UTC()
+	}
+
+	return manifest, nil
+}
+
+// preflightDeploy validates the cluster template before any Azure resources
+// are created. The service principal does not exist yet, so placeholder
+// credentials are rendered in its place.
+func (d *Deployer) preflightDeploy(ctx context.Context, spec DeploySpec, manifest *util.DeploymentManifest) error {
+	azureClient := d.Client
+
+	spClientID, spClientSecret := preflightClientID, preflightClientSecret
+	if manifest.ServicePrincipalClientID != "" {
+		spClientID, spClientSecret = manifest.ServicePrincipalClientID, manifest.ServicePrincipalClientSecret
+	} else if spec.NoCloudProvider {
+		spClientID, spClientSecret = "", ""
+	} else if spec.ServicePrincipalPassthrough {
+		spClientID, spClientSecret = spec.ServicePrincipalClientID, spec.ServicePrincipalClientSecret
+	}
+
+	flavorArgs, err := loadOrCreateFlavorArgs(spec, azureClient.Environment, azureClient.TenantID, spClientID, spClientSecret)
+	if err != nil {
+		return stepError(ctx, "deploy", "assets", err)
+	}
+	flavorArgs.Tags = util.ClusterTags(manifest, azureClient.Creator())
+
+	template, parameters, err := util.PopulateFlavor(manifest.Flavor, flavorArgs)
+	if err != nil {
+		return stepError(ctx, "deploy", "preflight", err)
+	}
+
+	createdResourceGroup, err := d.preflight(ctx, "deploy", spec.ResourceGroup, spec.Location, spec.DeploymentName, template, parameters)
+	if createdResourceGroup {
+		manifest.RecordCreated(util.JournalResourceGroup, spec.ResourceGroup)
+	}
+	return err
+}
+
+// ensureCloudProviderCredentials fills in the credentials the cluster's cloud
+// provider will use, journaling any directory objects it had to create.
+func (d *Deployer) ensureCloudProviderCredentials(spec DeploySpec, manifest *util.DeploymentManifest) error {
+	if spec.NoCloudProvider {
+		manifest.ApplicationID, manifest.ServicePrincipalClientID, manifest.ServicePrincipalClientSecret = "", "", ""
+		return nil
+	} else if spec.ServicePrincipalPassthrough {
+		manifest.ApplicationID = ""
+		manifest.ServicePrincipalClientID = spec.ServicePrincipalClientID
+		manifest.ServicePrincipalClientSecret = spec.ServicePrincipalClientSecret
+		return nil
+	}
+
+	appName := spec.DeploymentName
+	appURL := fmt.Sprintf("https://%s/", spec.DeploymentName)
+	credentials, err := d.Client.EnsureApp(appName, appURL, func(application *util.AdApplication) bool {
+		return createdApplication(manifest, application)
+	})
+	if credentials != nil {
+		if credentials.CreatedApplication {
+			manifest.RecordCreated(util.JournalApplication, credentials.ApplicationObjectID)
+		}
+		if credentials.CreatedServicePrincipal {
+			manifest.RecordCreated(util.JournalServicePrincipal, credentials.ServicePrincipalObjectID)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	manifest.ApplicationID = credentials.ApplicationID
+	manifest.ServicePrincipalClientID = credentials.ServicePrincipalObjectID
+	manifest.ServicePrincipalClientSecret = credentials.ClientSecret
+	return nil
+}
+
+// createdApplication reports whether the manifest records the application as
+// the deployment's own, either by its app id or by a journal entry.
+func createdApplication(manifest *util.DeploymentManifest, application *util.AdApplication) bool {
+	if manifest.ApplicationID != "" && manifest.ApplicationID == application.ApplicationID {
+		return true
+	}
+	for _, entry := range manifest.Journal {
+		if entry.Kind == util.JournalApplication && entry.ID == application.ObjectID {
+			return true
+		}
+	}
+	return false
+}
+
+func loadOrCreateFlavorArgs(spec DeploySpec, environment util.Environment, tenantID, spClientID, spClientSecret string) (util.FlavorArguments, error) {
+	sshPrivateKey, sshPublicKeyString, err := util.LoadOrCreateSaveSsh(spec.Username, spec.OutputDirectory)
+	if err != nil {
+		return util.FlavorArguments{}, fmt.Errorf("failed to create ssh assets: %q", err)
+	}
+
+	ca, apiserver, client, err := util.LoadOrCreateSavePki(spec.MasterFQDN, spec.MasterExtraFQDNs, spec.ClusterDomain, []net.IP{spec.MasterPrivateIP}, spec.OutputDirectory)
+	if err != nil {
+		return util.FlavorArguments{}, fmt.Errorf("failed to create pki assets: %q", err)
+	}
+
+	return convertSpecToFlavorArgs(spec, environment, tenantID, spClientID, spClientSecret, sshPrivateKey, sshPublicKeyString, ca, apiserver, client), nil
+}
+
+func convertSpecToFlavorArgs(spec DeploySpec, environment util.Environment, tenantID string,
+	spObjectID, spClientSecret string,
+	sshPrivateKey *rsa.PrivateKey, sshPublicKeyString string,
+	ca, apiserver, client *util.PkiKeyCertPair) util.FlavorArguments {
+	flavorArgs := util.FlavorArguments{
+		DeploymentName: spec.DeploymentName,
+		ResourceGroup:  spec.ResourceGroup,
+
+		TenantID: tenantID,
+
+		StorageEndpointSuffix: environment.StorageEndpointSuffix,
+
+		MasterSize:       spec.MasterSize,
+		NodePools:        spec.NodePools,
+		NodeCount:        util.TotalNodeCount(spec.NodePools),
+		Username:         spec.Username,
+		SshPublicKeyData: sshPublicKeyString,
+
+		KubernetesHyperkubeSpec: spec.KubernetesHyperkubeSpec,
+
+		ServicePrincipalClientID:     spObjectID,
+		ServicePrincipalClientSecret: spClientSecret,
+
+		MasterFQDN:      spec.MasterFQDN,
+		MasterPrivateIP: spec.MasterPrivateIP,
+		ClusterDomain:   spec.ClusterDomain,
+
+		CAKeyPair:        ca,
+		ApiserverKeyPair: apiserver,
+		ClientKeyPair:    client,
+	}
+	return flavorArgs
+}
+
+func newManifest(subscriptionID string, environment util.Environment, tenantID string, spec DeploySpec) *util.DeploymentManifest {
+	return &util.DeploymentManifest{
+		Created:        time.Now().UTC(),
+		SubscriptionID: subscriptionID,
+		TenantID:       tenantID,
+		Environment:    &environment,
+
+		Flavor:         DefaultFlavor,
+		DeploymentName: spec.DeploymentName,
+		ResourceGroup:  spec.ResourceGroup,
+		Location:       spec.Location,
+
+		MasterSize:              spec.MasterSize,
+		NodePools:               spec.NodePools,
+		Username:                spec.Username,
+		MasterFQDN:              spec.MasterFQDN,
+		MasterPrivateIP:         spec.MasterPrivateIP.String(),
+		ClusterDomain:           spec.ClusterDomain,
+		MasterExtraFQDNs:        spec.MasterExtraFQDNs,
+		KubernetesHyperkubeSpec: spec.KubernetesHyperkubeSpec,
+
+		ServicePrincipalPassthrough: spec.ServicePrincipalPassthrough,
+		NoCloudProvider:             spec.NoCloudProvider,
+	}
+}
+
+func specFromManifest(manifest *util.DeploymentManifest, outputDirectory string) (DeploySpec, error) {
+	masterPrivateIP := net.ParseIP(manifest.MasterPrivateIP)
+	if masterPrivateIP == nil {
+		return DeploySpec{}, fmt.Errorf("failed to parse the master private ip from the deployment manifest: %q", manifest.MasterPrivateIP)
+	}
+
+	return DeploySpec{
+		OutputDirectory:             outputDirectory,
+		DeploymentName:              manifest.DeploymentName,
+		ResourceGroup:               manifest.ResourceGroup,
+		Location:                    manifest.Location,
+		MasterSize:                  manifest.MasterSize,
+		NodePools:                   manifest.NodePools,
+		KubernetesHyperkubeSpec:     manifest.KubernetesHyperkubeSpec,
+		Username:                    manifest.Username,
+		MasterFQDN:                  manifest.MasterFQDN,
+		MasterPrivateIP:             masterPrivateIP,
+		ClusterDomain:               manifest.ClusterDomain,
+		MasterExtraFQDNs:            manifest.MasterExtraFQDNs,
+		ServicePrincipalPassthrough: manifest.ServicePrincipalPassthrough,
+		NoCloudProvider:             manifest.NoCloudProvider,
+	}, nil
+}
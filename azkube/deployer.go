@@ -0,0 +1,104 @@
+// Package azkube deploys, scales and destroys Kubernetes clusters in Azure.
+// The azkube command line tool is a thin wrapper around this package.
+package azkube
+
+import (
+	"context"
+	"os"
+	"path"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/util"
+)
+
+const (
+	DefaultFlavor = "coreos"
+)
+
+// Deployer drives the Azure side of a cluster's lifecycle using an
+// authenticated AzureClient.
+type Deployer struct {
+	Client *util.AzureClient
+}
+
+func NewDeployer(client *util.AzureClient) *Deployer {
+	return &Deployer{Client: client}
+}
+
+func DefaultOutputDirectory(deploymentName string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(wd, "_deployments", deploymentName), nil
+}
+
+// ResolveManifest locates the output directory of an existing deployment,
+// either directly or from the deployment name, and loads its manifest if one
+// was written. An explicitly requested output directory must contain one.
+func ResolveManifest(outputDirectory, deploymentName string) (string, *util.DeploymentManifest, error) {
+	explicit := outputDirectory != ""
+	if !explicit {
+		if deploymentName == "" {
+			return "", nil, nil
+		}
+
+		var err error
+		outputDirectory, err = DefaultOutputDirectory(deploymentName)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	exists, err := util.ManifestExists(outputDirectory)
+	if err != nil {
+		return "", nil, err
+	}
+	if !exists {
+		if explicit {
+			return "", nil, ErrManifestNotFound
+		}
+		log.Warnf("No deployment manifest found in %q.", outputDirectory)
+		return outputDirectory, nil, nil
+	}
+
+	manifest, err := util.LoadManifest(outputDirectory)
+	if err != nil {
+		return "", nil, err
+	}
+	log.Infof("Loaded deployment manifest. deployment=%q path=%q", manifest.DeploymentName, outputDirectory)
+
+	return outputDirectory, manifest, nil
+}
+
+func (d *Deployer) saveManifest(ctx context.Context, op string, manifest *util.DeploymentManifest, outputDirectory string) error {
+	err := util.SaveManifest(outputDirectory, manifest)
+	if err != nil {
+		return stepError(ctx, op, "manifest", err)
+	}
+	return nil
+}
+
+// runStep runs one resumable step of an operation. Steps already recorded as
+// completed in the manifest are skipped, and finished steps are persisted
+// immediately so an interrupted operation can pick up where it left off.
+func (d *Deployer) runStep(ctx context.Context, op string, manifest *util.DeploymentManifest, outputDirectory, step string, fn func() error) error {
+	if manifest.StepCompleted(step) {
+		log.Infof("Skipping step. It already completed. step=%q", step)
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return stepError(ctx, op, step, err)
+	}
+
+	err := fn()
+	if err != nil {
+		return stepError(ctx, op, step, err)
+	}
+
+	log.Debugf("Completed step. step=%q", step)
+	manifest.CompleteStep(step)
+	return d.saveManifest(ctx, op, manifest, outputDirectory)
+}
@@ -0,0 +1,336 @@
+package azkube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/util"
+)
+
+// DestroySpec identifies the resource group holding a deployment and the
+// directory objects created for its cloud provider. The manifest is optional;
+// without it, the application is looked up by the deployment's identifier uri.
+type DestroySpec struct {
+	OutputDirectory string
+	Manifest        *util.DeploymentManifest
+
+	DeploymentName string
+	ResourceGroup  string
+
+	// KeepApplication leaves the AAD application, service principal and
+	// role assignment in place.
+	KeepApplication bool
+
+	// ResourcesOnly deletes only the resources that belong to the
+	// deployment, for resource groups shared with other things. The resource
+	// group itself is deleted only if azkube created it and it ends up empty.
+	ResourcesOnly bool
+
+	// ForceUnlock removes management locks on the resource group and its
+	// resources before deleting anything. Without it, Destroy refuses to run
+	// on a locked group and returns a *LockedError.
+	ForceUnlock bool
+
+	// NoWait returns as soon as Azure has accepted the deletion of the
+	// resource group, with a DestroyOperation to follow it by. It cannot be
+	// combined with ResourcesOnly.
+	NoWait bool
+}
+
+// DestroyPlan lists what Destroy would delete, so callers can confirm first.
+type DestroyPlan struct {
+	ResourceGroup       string `json:"resourceGroup"`
+	ResourceGroupExists bool   `json:"resourceGroupExists"`
+	DeleteResourceGroup bool   `json:"deleteResourceGroup"`
+
+	// Resources are the resources that will be deleted, in deletion order
+	// when ResourcesOnly is set.
+	Resources     []resources.GenericResource `json:"resources"`
+	ResourcesOnly bool                        `json:"resourcesOnly"`
+
+	// ForeignResources counts the resources in the group that don't belong
+	// to the deployment. They are only deleted without ResourcesOnly.
+	ForeignResources int `json:"foreignResources"`
+
+	// Locks are the management locks that prevent the deletion and that
+	// ForceUnlock removes: the protect lock and locks on the deployment's
+	// own resources. ForeignLocks are everybody else's; they are never
+	// removed, and block the deletion of the whole resource group.
+	Locks        []util.ManagementLock `json:"locks"`
+	ForeignLocks []util.ManagementLock `json:"foreignLocks"`
+
+	// Application and ServicePrincipal are nil when there are none to delete.
+	Application       *util.AdApplication      `json:"application,omitempty"`
+	ServicePrincipal  *util.AdServicePrincipal `json:"servicePrincipal,omitempty"`
+	RoleAssignmentIDs []string                 `json:"roleAssignmentIds"`
+}
+
+func (d *Deployer) PlanDestroy(ctx context.Context, spec DestroySpec) (*DestroyPlan, error) {
+	if spec.ResourceGroup == "" {
+		return nil, &SpecError{Field: "ResourceGroup", Message: "must be set"}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, stepError(ctx, "destroy", "plan", err)
+	}
+
+	if spec.ResourcesOnly && spec.NoWait {
+		return nil, &SpecError{Field: "NoWait", Message: "can't be combined with ResourcesOnly, whose resources are deleted in order"}
+	}
+	if spec.ResourcesOnly && spec.DeploymentName == "" {
+		return nil, &SpecError{Field: "DeploymentName", Message: "must be set to delete only the deployment's resources"}
+	}
+
+	plan := &DestroyPlan{ResourceGroup: spec.ResourceGroup, ResourcesOnly: spec.ResourcesOnly}
+
+	exists, err := d.Client.ResourceGroupExists(spec.ResourceGroup)
+	if err != nil {
+		return nil, stepError(ctx, "destroy", "plan", err)
+	}
+	plan.ResourceGroupExists = exists
+	if exists {
+		groupResources, err := d.Client.ListResources(spec.ResourceGroup)
+		if err != nil {
+			return nil, stepError(ctx, "destroy", "plan", err)
+		}
+		plan.Resources = *groupResources
+		plan.DeleteResourceGroup = true
+
+		owned := []resources.GenericResource{}
+		if spec.DeploymentName != "" {
+			owned = deploymentResources(plan.Resources, spec.DeploymentName, spec.Manifest)
+			plan.ForeignResources = len(plan.Resources) - len(owned)
+			if spec.ResourcesOnly {
+				plan.Resources = owned
+				plan.DeleteResourceGroup = createdResourceGroup(spec.Manifest, spec.ResourceGroup)
+			}
+		}
+
+		managementLocks, err := d.Client.ListResourceGroupLocks(spec.ResourceGroup)
+		if err != nil {
+			return nil, stepError(ctx, "destroy", "plan", err)
+		}
+		plan.Locks, plan.ForeignLocks = splitLocks(managementLocks, owned)
+	}
+
+	err = d.planApplication(spec, plan)
+	if err != nil {
+		return nil, stepError(ctx, "destroy", "plan", err)
+	}
+
+	return plan, nil
+}
+
+func deploymentResources(all []resources.GenericResource, deploymentName string, manifest *util.DeploymentManifest) []resources.GenericResource {
+	pools := []string{}
+	if manifest != nil {
+		for _, pool := range manifest.NodePools {
+			pools = append(pools, pool.Name)
+		}
+	}
+
+	owned := []resources.GenericResource{}
+	for _, resource := range all {
+		if util.DeploymentOwnsResource(resource, deploymentName, pools) {
+			owned = append(owned, resource)
+		}
+	}
+	util.SortResourcesForDeletion(owned)
+	return owned
+}
+
+// createdResourceGroup reports whether the manifest's journal records that
+// azkube created the resource group. Without a manifest, it is assumed not.
+func createdResourceGroup(manifest *util.DeploymentManifest, resourceGroup string) bool {
+	if manifest == nil {
+		return false
+	}
+	for _, entry := range manifest.Journal {
+		if entry.Kind == util.JournalResourceGroup && entry.ID == resourceGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// planApplication finds the application deploy created for the cluster's
+// cloud provider. Service principals passed through by the user are never
+// deleted. The application is only looked up by its identifier uri when there
+// is no manifest to record its app id.
+func (d *Deployer) planApplication(spec DestroySpec, plan *DestroyPlan) error {
+	manifest := spec.Manifest
+	if spec.KeepApplication || (manifest != nil && (manifest.ServicePrincipalPassthrough || manifest.NoCloudProvider)) {
+		return nil
+	}
+
+	var application *util.AdApplication
+	var err error
+	if manifest != nil && manifest.ApplicationID != "" {
+		application, err = d.Client.GetAppByAppID(manifest.ApplicationID)
+	} else if manifest == nil && spec.DeploymentName != "" {
+		application, err = d.Client.GetAppByIdentifierURI(fmt.Sprintf("https://%s/", spec.DeploymentName))
+	}
+	if err != nil || application == nil {
+		return err
+	}
+	plan.Application = application
+
+	plan.ServicePrincipal, err = d.Client.GetServicePrincipalByAppID(application.ApplicationID)
+	if err != nil || plan.ServicePrincipal == nil || !plan.ResourceGroupExists {
+		return err
+	}
+
+	plan.RoleAssignmentIDs, err = d.Client.ListRoleAssignmentIDs(spec.ResourceGroup, plan.ServicePrincipal.ObjectID)
+	return err
+}
+
+// Destroy deletes the deployment's resource group and everything in it, then
+// its role assignment, service principal and application. With NoWait, the
+// resource group is still being deleted when Destroy returns, and the
+// returned operation reports its progress. Otherwise the operation is nil.
+func (d *Deployer) Destroy(ctx context.Context, spec DestroySpec) (*DestroyOperation, error) {
+	plan, err := d.PlanDestroy(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(plan.ForeignLocks) > 0 && !plan.ResourcesOnly {
+		return nil, &LockedError{ResourceGroup: plan.ResourceGroup, Locks: plan.ForeignLocks, Foreign: true}
+	}
+	for _, lock := range plan.ForeignLocks {
+		log.Warnf("Leaving a management lock that doesn't belong to the deployment. lock=%q level=%q id=%q", lock.Name, lock.Level, lock.ID)
+	}
+
+	if len(plan.Locks) > 0 {
+		if !spec.ForceUnlock {
+			return nil, &LockedError{ResourceGroup: plan.ResourceGroup, Locks: plan.Locks}
+		}
+		err = d.unlock(ctx, plan)
+		if err != nil {
+			return nil, err
+		}
+		if spec.Manifest != nil {
+			spec.Manifest.Protected = false
+		}
+	}
+
+	if plan.ResourcesOnly {
+		for _, resource := range plan.Resources {
+			err = d.Client.DeleteResource(resource, ctx.Done())
+			if err != nil {
+				return nil, stepError(ctx, "destroy", "resources", err)
+			}
+		}
+	}
+
+	var operation *DestroyOperation
+	resourceGroupDeleted := false
+	if plan.DeleteResourceGroup && spec.NoWait {
+		operation, err = d.beginDeleteResourceGroup(ctx, plan)
+		if err != nil {
+			return nil, err
+		}
+		resourceGroupDeleted = true
+	} else if plan.DeleteResourceGroup {
+		resourceGroupDeleted, err = d.deleteResourceGroup(ctx, plan)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, roleAssignmentID := range plan.RoleAssignmentIDs {
+		err = d.Client.DeleteRoleAssignment(roleAssignmentID)
+		if err != nil {
+			return operation, stepError(ctx, "destroy", util.StepRoleAssignment, err)
+		}
+	}
+
+	if plan.ServicePrincipal != nil {
+		log.Infof("Deleting service principal. objectId=%q", plan.ServicePrincipal.ObjectID)
+		err = d.Client.DeleteServicePrincipal(plan.ServicePrincipal.ObjectID)
+		if err != nil {
+			return operation, stepError(ctx, "destroy", util.StepServicePrincipal, err)
+		}
+	}
+
+	if plan.Application != nil {
+		log.Infof("Deleting application. appId=%q", plan.Application.ApplicationID)
+		err = d.Client.DeleteApp(plan.Application.ObjectID)
+		if err != nil {
+			return operation, stepError(ctx, "destroy", util.StepServicePrincipal, err)
+		}
+	}
+
+	if spec.Manifest != nil {
+		// only directory objects and a resource group that were kept can
+		// still exist
+		journal := []util.JournalEntry{}
+		for _, entry := range spec.Manifest.Journal {
+			keptApplication := plan.Application == nil && (entry.Kind == util.JournalApplication || entry.Kind == util.JournalServicePrincipal)
+			keptResourceGroup := !resourceGroupDeleted && entry.Kind == util.JournalResourceGroup
+			if keptApplication || keptResourceGroup {
+				journal = append(journal, entry)
+			}
+		}
+		spec.Manifest.Journal = journal
+		spec.Manifest.Stopped = false
+		forgetRolledBack(spec.Manifest, util.JournalEntry{Kind: util.JournalResourceGroup})
+		if plan.Application != nil {
+			forgetRolledBack(spec.Manifest, util.JournalEntry{Kind: util.JournalApplication})
+		}
+		err = d.saveManifest(ctx, "destroy", spec.Manifest, spec.OutputDirectory)
+		if err != nil {
+			return operation, err
+		}
+	}
+
+	if operation != nil && spec.OutputDirectory != "" {
+		err = SaveDestroyOperation(spec.OutputDirectory, operation)
+		if err != nil {
+			log.Warnf("Failed to save the destroy operation: %q", err)
+		}
+	}
+
+	return operation, nil
+}
+
+// deleteResourceGroup deletes the plan's resource group. After a
+// ResourcesOnly destroy, it is only deleted if nothing else is left in it.
+func (d *Deployer) deleteResourceGroup(ctx context.Context, plan *DestroyPlan) (bool, error) {
+	if plan.ResourcesOnly {
+		remaining, err := d.Client.ListResources(plan.ResourceGroup)
+		if err != nil {
+			return false, stepError(ctx, "destroy", "resourceGroup", err)
+		}
+		if len(*remaining) > 0 {
+			log.Warnf("Keeping resource group, it still holds %d other resource(s). resourceGroup=%q", len(*remaining), plan.ResourceGroup)
+			return false, nil
+		}
+	}
+
+	log.Infof("Starting the deletion of resource group. resourceGroup=%q", plan.ResourceGroup)
+	_, err := d.Client.GroupsClient.Delete(plan.ResourceGroup, ctx.Done())
+	if err != nil {
+		return false, stepError(ctx, "destroy", "resourceGroup", err)
+	}
+	log.Infof("Finished the deletion of resource group. resourceGroup=%q", plan.ResourceGroup)
+	return true, nil
+}
+
+func (d *Deployer) beginDeleteResourceGroup(ctx context.Context, plan *DestroyPlan) (*DestroyOperation, error) {
+	log.Infof("Starting the deletion of resource group. resourceGroup=%q", plan.ResourceGroup)
+	statusURL, err := d.Client.BeginDeleteResourceGroup(plan.ResourceGroup)
+	if err != nil {
+		return nil, stepError(ctx, "destroy", "resourceGroup", err)
+	}
+
+	return &DestroyOperation{
+		SubscriptionID: d.Client.SubscriptionID,
+		ResourceGroup:  plan.ResourceGroup,
+		StatusURL:      statusURL,
+		Started:        time.Now().UTC(),
+	}, nil
+}
@@ -0,0 +1,90 @@
+package azkube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"time"
+
+	"github.com/colemickens/azkube/util"
+)
+
+const (
+	DestroyOperationFilename = "destroy-operation.json"
+
+	DestroyStatusDeleting = "Deleting"
+	DestroyStatusDeleted  = "Deleted"
+)
+
+// DestroyOperation identifies a resource group deletion started by
+// Destroy with NoWait.
+type DestroyOperation struct {
+	SubscriptionID string    `json:"subscriptionId"`
+	ResourceGroup  string    `json:"resourceGroup"`
+	StatusURL      string    `json:"statusUrl,omitempty"`
+	Started        time.Time `json:"started"`
+}
+
+// DestroyStatus reports whether the resource group of a destroy operation is
+// still being deleted. An operation without a status url is followed by
+// checking whether the resource group still exists.
+func (d *Deployer) DestroyStatus(ctx context.Context, operation *DestroyOperation) (string, error) {
+	if operation.ResourceGroup == "" {
+		return "", &SpecError{Field: "ResourceGroup", Message: "must be set"}
+	}
+	if err := ctx.Err(); err != nil {
+		return "", stepError(ctx, "destroy-status", "poll", err)
+	}
+
+	if operation.StatusURL != "" {
+		done, err := d.Client.OperationDone(operation.StatusURL)
+		if err != nil {
+			return "", stepError(ctx, "destroy-status", "poll", err)
+		}
+		if !done {
+			return DestroyStatusDeleting, nil
+		}
+		return DestroyStatusDeleted, nil
+	}
+
+	exists, err := d.Client.ResourceGroupExists(operation.ResourceGroup)
+	if err != nil {
+		return "", stepError(ctx, "destroy-status", "poll", err)
+	}
+	if exists {
+		return DestroyStatusDeleting, nil
+	}
+	return DestroyStatusDeleted, nil
+}
+
+func SaveDestroyOperation(outputDirectory string, operation *DestroyOperation) error {
+	contents, err := json.MarshalIndent(operation, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.SaveDeploymentFile(outputDirectory, DestroyOperationFilename, string(contents), 0600)
+}
+
+// LoadDestroyOperation reads the operation saved by a NoWait destroy. It
+// returns nil if there is none.
+func LoadDestroyOperation(outputDirectory string) (*DestroyOperation, error) {
+	exists, err := util.DeploymentFileExists(outputDirectory, DestroyOperationFilename)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	operationPath := path.Join(outputDirectory, DestroyOperationFilename)
+	contents, err := ioutil.ReadFile(operationPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var operation DestroyOperation
+	err = json.Unmarshal(contents, &operation)
+	if err != nil {
+		return nil, fmt.Errorf("destroy: failed to parse %q: %q", operationPath, err)
+	}
+	return &operation, nil
+}
@@ -0,0 +1,47 @@
+package azkube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrManifestNotFound is returned when an output directory that was
+	// explicitly requested does not contain a deployment manifest.
+	ErrManifestNotFound = errors.New("azkube: deployment manifest not found")
+
+	// ErrDeploymentMismatch is returned when resuming a deployment whose
+	// manifest belongs to another subscription or azure environment.
+	ErrDeploymentMismatch = errors.New("azkube: deployment belongs to a different subscription or environment")
+)
+
+// SpecError reports an invalid or incomplete spec passed to a Deployer.
+type SpecError struct {
+	Field   string
+	Message string
+}
+
+func (e *SpecError) Error() string {
+	return fmt.Sprintf("azkube: invalid spec: %s: %s", e.Field, e.Message)
+}
+
+// StepError reports the failure of one step of an operation. Err is the
+// underlying error, which is context.Canceled or context.DeadlineExceeded
+// when the operation was interrupted through its context.
+type StepError struct {
+	Op   string
+	Step string
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("azkube: %s: %s: %v", e.Op, e.Step, e.Err)
+}
+
+func stepError(ctx context.Context, op, step string, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	return &StepError{Op: op, Step: step, Err: err}
+}
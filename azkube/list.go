@@ -0,0 +1,78 @@
+package azkube
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/colemickens/azkube/util"
+)
+
+// Cluster summarizes a cluster deployed by azkube, as recorded in the tags
+// of its resource group.
+type Cluster struct {
+	DeploymentName string    `json:"deploymentName"`
+	ResourceGroup  string    `json:"resourceGroup"`
+	Location       string    `json:"location"`
+	Flavor         string    `json:"flavor"`
+	MasterFQDN     string    `json:"masterFqdn"`
+	NodeSize       string    `json:"nodeSize"`
+	NodeCount      int       `json:"nodeCount"`
+	NodePools      string    `json:"nodePools,omitempty"`
+	State          string    `json:"state,omitempty"`
+	Version        string    `json:"azkubeVersion"`
+	Creator        string    `json:"creator"`
+	Created        time.Time `json:"created"`
+}
+
+// List finds the clusters azkube has deployed in the subscription, newest
+// first.
+func (d *Deployer) List(ctx context.Context) ([]Cluster, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, stepError(ctx, "list", "resourceGroups", err)
+	}
+
+	resourceGroups, err := d.Client.ListResourceGroupsWithTag(util.TagDeployment)
+	if err != nil {
+		return nil, stepError(ctx, "list", "resourceGroups", err)
+	}
+
+	clusters := []Cluster{}
+	for _, resourceGroup := range resourceGroups {
+		tags := map[string]string{}
+		if resourceGroup.Tags != nil {
+			for key, value := range *resourceGroup.Tags {
+				tags[key] = to.String(value)
+			}
+		}
+
+		nodeCount, _ := strconv.Atoi(tags[util.TagNodeCount])
+		created, _ := time.Parse(time.RFC3339, tags[util.TagCreated])
+
+		clusters = append(clusters, Cluster{
+			DeploymentName: tags[util.TagDeployment],
+			ResourceGroup:  to.String(resourceGroup.Name),
+			Location:       to.String(resourceGroup.Location),
+			Flavor:         tags[util.TagFlavor],
+			MasterFQDN:     tags[util.TagMasterFQDN],
+			NodeSize:       tags[util.TagNodeSize],
+			NodeCount:      nodeCount,
+			NodePools:      tags[util.TagNodePools],
+			State:          tags[util.TagState],
+			Version:        tags[util.TagVersion],
+			Creator:        tags[util.TagCreator],
+			Created:        created,
+		})
+	}
+
+	sort.Sort(clustersByCreated(clusters))
+	return clusters, nil
+}
+
+type clustersByCreated []Cluster
+
+func (c clustersByCreated) Len() int           { return len(c) }
+func (c clustersByCreated) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c clustersByCreated) Less(i, j int) bool { return c[i].Created.After(c[j].Created) }
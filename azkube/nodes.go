@@ -0,0 +1,100 @@
+package azkube
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/util"
+	k8s "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// clusterFlavorArgs returns the arguments needed to talk to the cluster's
+// apiserver, using the manifest and the pki saved in the output directory.
+func clusterFlavorArgs(manifest *util.DeploymentManifest, outputDirectory string) (util.FlavorArguments, error) {
+	if manifest == nil {
+		return util.FlavorArguments{}, fmt.Errorf("a deployment manifest is required to connect to the cluster")
+	}
+	ca, client, err := util.LoadClientPki(outputDirectory)
+	if err != nil {
+		return util.FlavorArguments{}, err
+	}
+
+	return util.FlavorArguments{
+		DeploymentName: manifest.DeploymentName,
+		MasterFQDN:     manifest.MasterFQDN,
+		CAKeyPair:      ca,
+		ClientKeyPair:  client,
+	}, nil
+}
+
+// drainInstances cordons and drains the nodes of scale set instances that are
+// about to be deleted. It returns the client it used so the Node objects can
+// be deleted afterwards.
+func drainInstances(ctx context.Context, op string, manifest *util.DeploymentManifest, outputDirectory string, instances []util.ScaleSetInstance) (*k8s.Client, error) {
+	flavorArgs, err := clusterFlavorArgs(manifest, outputDirectory)
+	if err != nil {
+		return nil, stepError(ctx, op, "drain", err)
+	}
+	kubeClient, err := util.NewKubernetesClient(flavorArgs.MasterFQDN, flavorArgs.CAKeyPair, flavorArgs.ClientKeyPair)
+	if err != nil {
+		return nil, stepError(ctx, op, "drain", err)
+	}
+
+	for _, instance := range instances {
+		if instance.NodeName == "" {
+			log.Warnf("Scale set instance has no computer name, not draining it. instance=%q", instance.InstanceID)
+			continue
+		}
+		err = util.DrainNode(kubeClient, instance.NodeName, ctx.Done())
+		if err != nil {
+			return nil, stepError(ctx, op, "drain", err)
+		}
+	}
+
+	return kubeClient, nil
+}
+
+// deleteNodeObjects removes the Node objects of deleted instances. kubeClient
+// may be nil if the nodes were not drained, in which case nothing is done.
+func deleteNodeObjects(kubeClient *k8s.Client, instances []util.ScaleSetInstance) {
+	if kubeClient == nil {
+		return
+	}
+	for _, instance := range instances {
+		if instance.NodeName == "" {
+			continue
+		}
+		err := util.DeleteNode(kubeClient, instance.NodeName)
+		if err != nil {
+			log.Warnf("Failed to delete node object. node=%q: %q", instance.NodeName, err)
+		}
+	}
+}
+
+// waitForPoolNodes waits until the cluster reports count Ready nodes in a
+// node pool, deleting Node objects left behind by instances that are gone.
+func (d *Deployer) waitForPoolNodes(ctx context.Context, op string, manifest *util.DeploymentManifest, outputDirectory, resourceGroup, pool string, count int) error {
+	flavorArgs, err := clusterFlavorArgs(manifest, outputDirectory)
+	if err != nil {
+		return stepError(ctx, op, util.StepValidation, err)
+	}
+	flavorArgs.NodeCount = count
+
+	instances, err := d.Client.ListScaleSetInstances(resourceGroup, util.NodeScaleSetName(manifest.DeploymentName, pool))
+	if err != nil {
+		return stepError(ctx, op, util.StepValidation, err)
+	}
+	liveNodeNames := []string{}
+	for _, instance := range instances {
+		liveNodeNames = append(liveNodeNames, instance.NodeName)
+	}
+
+	err = util.ValidateNodeCount(flavorArgs, util.NodeNamePrefix(manifest.DeploymentName, pool), liveNodeNames, ctx.Done())
+	if err != nil {
+		return stepError(ctx, op, util.StepValidation, err)
+	}
+
+	log.Infof("Node pool has %d ready nodes. pool=%q", count, pool)
+	return nil
+}
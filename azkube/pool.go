@@ -0,0 +1,174 @@
+package azkube
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/util"
+	k8s "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// NodePoolSpec describes a node pool to add to, or remove from, a deployed
+// cluster. Adding a pool requires the manifest, since the cluster template is
+// deployed again with the new pool list.
+type NodePoolSpec struct {
+	OutputDirectory string
+	Manifest        *util.DeploymentManifest
+
+	DeploymentName string
+	ResourceGroup  string
+
+	// Pool is the pool to add. Only its Name is used when removing a pool.
+	Pool util.NodePool
+
+	// SkipDrain removes a pool's nodes without draining them first.
+	SkipDrain bool
+
+	// SkipValidation returns without waiting for a new pool's nodes to
+	// become Ready.
+	SkipValidation bool
+}
+
+// AddPool creates a new node pool by deploying the cluster template again
+// with the pool appended. The counts and sizes of the existing pools are
+// refreshed from Azure first so the deployment leaves them as they are.
+func (d *Deployer) AddPool(ctx context.Context, spec NodePoolSpec) error {
+	manifest := spec.Manifest
+	if manifest == nil {
+		return &SpecError{Field: "Manifest", Message: "is required to add a node pool"}
+	}
+	if _, ok := util.FindNodePool(manifest.NodePools, spec.Pool.Name); ok {
+		return &SpecError{Field: "Pool", Message: fmt.Sprintf("deployment already has a node pool %q", spec.Pool.Name)}
+	}
+
+	err := d.ensureRunning(ctx, "pool-add", manifest.ResourceGroup, manifest.DeploymentName, manifest)
+	if err != nil {
+		return err
+	}
+
+	livePools, err := d.Client.ListNodePools(manifest.ResourceGroup, manifest.DeploymentName)
+	if err != nil {
+		return stepError(ctx, "pool-add", "plan", err)
+	}
+	pools := []util.NodePool{}
+	for _, pool := range manifest.NodePools {
+		if i, ok := util.FindNodePool(livePools, pool.Name); ok {
+			pool.Size = livePools[i].Size
+			pool.Count = livePools[i].Count
+		}
+		pools = append(pools, pool)
+	}
+	pools = append(pools, spec.Pool)
+
+	hyperkubeSpec := manifest.KubernetesHyperkubeSpec
+	if hyperkubeSpec == "" {
+		hyperkubeSpec = DefaultKubernetesHyperkubeSpec
+	}
+	err = util.ValidateNodePools(pools)
+	if err == nil {
+		err = util.ValidateNodePoolTaints([]util.NodePool{spec.Pool}, hyperkubeSpec)
+	}
+	if err != nil {
+		return &SpecError{Field: "Pool", Message: err.Error()}
+	}
+
+	deploySpec, err := specFromManifest(manifest, spec.OutputDirectory)
+	if err != nil {
+		return err
+	}
+	deploySpec.NodePools = pools
+
+	flavorArgs, err := loadOrCreateFlavorArgs(deploySpec, d.Client.Environment, d.Client.TenantID, manifest.ServicePrincipalClientID, manifest.ServicePrincipalClientSecret)
+	if err != nil {
+		return stepError(ctx, "pool-add", "assets", err)
+	}
+
+	creator := d.Client.Creator()
+	if tags, err := d.Client.ResourceGroupTags(manifest.ResourceGroup); err == nil && tags[util.TagCreator] != "" {
+		creator = tags[util.TagCreator]
+	}
+	manifest.NodePools = pools
+	flavorArgs.Tags = util.ClusterTags(manifest, creator)
+
+	log.Infof("Adding node pool. deployment=%q pool=%q nodeSize=%q nodeCount=%d", manifest.DeploymentName, spec.Pool.Name, spec.Pool.Size, spec.Pool.Count)
+	err = d.Client.DeployFlavor(manifest.Flavor, flavorArgs, spec.OutputDirectory, ctx.Done())
+	if err != nil {
+		manifest.NodePools = pools[:len(pools)-1]
+		return stepError(ctx, "pool-add", util.StepArmDeployment, err)
+	}
+
+	d.tagNodePools(manifest.ResourceGroup, manifest.DeploymentName, manifest)
+	err = d.saveManifest(ctx, "pool-add", manifest, spec.OutputDirectory)
+	if err != nil {
+		return err
+	}
+
+	if spec.SkipValidation {
+		log.Warnf("Skipping validation of the new node pool.")
+		return nil
+	}
+	return d.waitForPoolNodes(ctx, "pool-add", manifest, spec.OutputDirectory, manifest.ResourceGroup, spec.Pool.Name, spec.Pool.Count)
+}
+
+// RemovePool drains every node of a node pool and deletes its scale set. The
+// last remaining pool of a cluster cannot be removed.
+func (d *Deployer) RemovePool(ctx context.Context, spec NodePoolSpec) error {
+	if spec.DeploymentName == "" {
+		return &SpecError{Field: "DeploymentName", Message: "must be set"}
+	}
+	if spec.ResourceGroup == "" {
+		return &SpecError{Field: "ResourceGroup", Message: "must be set"}
+	}
+
+	err := d.ensureRunning(ctx, "pool-remove", spec.ResourceGroup, spec.DeploymentName, spec.Manifest)
+	if err != nil {
+		return err
+	}
+
+	pools, err := d.Client.ListNodePools(spec.ResourceGroup, spec.DeploymentName)
+	if err != nil {
+		return stepError(ctx, "pool-remove", "plan", err)
+	}
+	if spec.Manifest != nil {
+		pools = spec.Manifest.NodePools
+	}
+	if _, ok := util.FindNodePool(pools, spec.Pool.Name); !ok {
+		return &SpecError{Field: "Pool", Message: fmt.Sprintf("deployment has no node pool %q", spec.Pool.Name)}
+	}
+	if len(pools) == 1 {
+		return &SpecError{Field: "Pool", Message: "a cluster needs at least one node pool"}
+	}
+
+	scaleSetName := util.NodeScaleSetName(spec.DeploymentName, spec.Pool.Name)
+	instances, err := d.Client.ListScaleSetInstances(spec.ResourceGroup, scaleSetName)
+	if err != nil {
+		return stepError(ctx, "pool-remove", "plan", err)
+	}
+
+	var kubeClient *k8s.Client
+	if !spec.SkipDrain {
+		kubeClient, err = drainInstances(ctx, "pool-remove", spec.Manifest, spec.OutputDirectory, instances)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = d.Client.DeleteScaleSet(spec.ResourceGroup, scaleSetName, ctx.Done())
+	if err != nil {
+		return stepError(ctx, "pool-remove", "delete-scaleset", err)
+	}
+
+	deleteNodeObjects(kubeClient, instances)
+
+	if spec.Manifest != nil {
+		i, _ := util.FindNodePool(spec.Manifest.NodePools, spec.Pool.Name)
+		spec.Manifest.NodePools = append(spec.Manifest.NodePools[:i], spec.Manifest.NodePools[i+1:]...)
+	}
+	d.tagNodePools(spec.ResourceGroup, spec.DeploymentName, spec.Manifest)
+
+	if spec.Manifest != nil {
+		return d.saveManifest(ctx, "pool-remove", spec.Manifest, spec.OutputDirectory)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package azkube
+
+import (
+	"context"
+	"errors"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/util"
+)
+
+// ErrClusterStopped is returned by operations that need a running cluster,
+// such as Scale, while the cluster is stopped.
+var ErrClusterStopped = errors.New("azkube: cluster is stopped, start it first")
+
+// PowerSpec identifies a deployed cluster to stop or start. The manifest is
+// optional, but is required for Start to validate the cluster and is updated
+// in OutputDirectory when present.
+type PowerSpec struct {
+	OutputDirectory string
+	Manifest        *util.DeploymentManifest
+
+	DeploymentName string
+	ResourceGroup  string
+
+	// SkipValidation returns from Start as soon as the vms are running,
+	// without waiting for the cluster to become healthy.
+	SkipValidation bool
+}
+
+func (spec PowerSpec) validate() error {
+	if spec.DeploymentName == "" {
+		return &SpecError{Field: "DeploymentName", Message: "must be set"}
+	}
+	if spec.ResourceGroup == "" {
+		return &SpecError{Field: "ResourceGroup", Message: "must be set"}
+	}
+	return nil
+}
+
+// Stop deallocates the node scale sets and then the master vm. Disks, ips
+// and the resource group are kept, so the cluster can be started again. The
+// stopped state is recorded before anything is deallocated.
+func (d *Deployer) Stop(ctx context.Context, spec PowerSpec) error {
+	err := spec.validate()
+	if err != nil {
+		return err
+	}
+
+	pools, err := d.Client.ListNodePools(spec.ResourceGroup, spec.DeploymentName)
+	if err != nil {
+		return stepError(ctx, "stop", "plan", err)
+	}
+
+	err = d.recordPowerState(ctx, "stop", spec, true)
+	if err != nil {
+		return err
+	}
+
+	for _, pool := range pools {
+		err = d.Client.DeallocateScaleSet(spec.ResourceGroup, util.NodeScaleSetName(spec.DeploymentName, pool.Name), ctx.Done())
+		if err != nil {
+			return stepError(ctx, "stop", "deallocate-nodes", err)
+		}
+	}
+
+	err = d.Client.DeallocateVM(spec.ResourceGroup, util.MasterVMName(spec.DeploymentName), ctx.Done())
+	if err != nil {
+		return stepError(ctx, "stop", "deallocate-master", err)
+	}
+
+	return nil
+}
+
+// Start starts the master vm, waits for the apiserver and etcd to become
+// healthy, then starts the node scale sets and validates the whole cluster.
+func (d *Deployer) Start(ctx context.Context, spec PowerSpec) error {
+	err := spec.validate()
+	if err != nil {
+		return err
+	}
+
+	var flavorArgs util.FlavorArguments
+	validate := !spec.SkipValidation
+	if validate {
+		flavorArgs, err = clusterFlavorArgs(spec.Manifest, spec.OutputDirectory)
+		if err != nil {
+			return stepError(ctx, "start", util.StepValidation, err)
+		}
+		flavorArgs.NodeCount = util.TotalNodeCount(spec.Manifest.NodePools)
+	}
+
+	pools, err := d.Client.ListNodePools(spec.ResourceGroup, spec.DeploymentName)
+	if err != nil {
+		return stepError(ctx, "start", "plan", err)
+	}
+
+	err = d.Client.StartVM(spec.ResourceGroup, util.MasterVMName(spec.DeploymentName), ctx.Done())
+	if err != nil {
+		return stepError(ctx, "start", "start-master", err)
+	}
+	if validate {
+		err = util.ValidateMaster(flavorArgs, ctx.Done())
+		if err != nil {
+			return stepError(ctx, "start", util.StepValidation, err)
+		}
+	}
+
+	for _, pool := range pools {
+		err = d.Client.StartScaleSet(spec.ResourceGroup, util.NodeScaleSetName(spec.DeploymentName, pool.Name), ctx.Done())
+		if err != nil {
+			return stepError(ctx, "start", "start-nodes", err)
+		}
+	}
+
+	err = d.recordPowerState(ctx, "start", spec, false)
+	if err != nil {
+		return err
+	}
+
+	if !validate {
+		log.Warnf("Skipping validation of the started cluster.")
+		return nil
+	}
+	err = util.ValidateKubernetes(flavorArgs, ctx.Done())
+	if err != nil {
+		return stepError(ctx, "start", util.StepValidation, err)
+	}
+	return nil
+}
+
+// recordPowerState tags the resource group with the cluster's state and saves
+// it in the manifest, if there is one. The resource group is only tagged if
+// it is tagged as the deployment's own, since it may hold other deployments.
+func (d *Deployer) recordPowerState(ctx context.Context, op string, spec PowerSpec, stopped bool) error {
+	state := util.ClusterStateRunning
+	if stopped {
+		state = util.ClusterStateStopped
+	}
+
+	tags, err := d.Client.ResourceGroupTags(spec.ResourceGroup)
+	if err != nil {
+		return stepError(ctx, op, "record-state", err)
+	}
+	if tags[util.TagDeployment] == spec.DeploymentName {
+		err = d.Client.TagResourceGroup(spec.ResourceGroup, map[string]string{util.TagState: state})
+		if err != nil {
+			return stepError(ctx, op, "record-state", err)
+		}
+	} else {
+		log.Debugf("Not recording the cluster's state in the tags of a resource group it shares. resourceGroup=%q", spec.ResourceGroup)
+	}
+
+	if spec.Manifest != nil {
+		spec.Manifest.Stopped = stopped
+		return d.saveManifest(ctx, op, spec.Manifest, spec.OutputDirectory)
+	}
+	return nil
+}
+
+// ensureRunning returns ErrClusterStopped if the manifest or the resource
+// group's tags record the cluster as stopped. The tags are only trusted if
+// they belong to the deployment.
+func (d *Deployer) ensureRunning(ctx context.Context, op, resourceGroup, deploymentName string, manifest *util.DeploymentManifest) error {
+	if manifest != nil && manifest.Stopped {
+		return ErrClusterStopped
+	}
+
+	tags, err := d.Client.ResourceGroupTags(resourceGroup)
+	if err != nil {
+		return stepError(ctx, op, "plan", err)
+	}
+	if tags[util.TagDeployment] == deploymentName && tags[util.TagState] == util.ClusterStateStopped {
+		return ErrClusterStopped
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package azkube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+	"github.com/Azure/go-autorest/autorest/to"
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/util"
+)
+
+// LockedError is returned by Destroy when the resource group, or a resource
+// in it, has a management lock and ForceUnlock was not set. Foreign is set
+// when the locks were not added by azkube or are on resources that don't
+// belong to the deployment; ForceUnlock never removes those.
+type LockedError struct {
+	ResourceGroup string
+	Locks         []util.ManagementLock
+	Foreign       bool
+}
+
+func (e *LockedError) Error() string {
+	if e.Foreign {
+		return fmt.Sprintf("azkube: resource group %q is protected by %d management lock(s) that don't belong to the deployment", e.ResourceGroup, len(e.Locks))
+	}
+	return fmt.Sprintf("azkube: resource group %q is protected by %d management lock(s)", e.ResourceGroup, len(e.Locks))
+}
+
+// ProtectSpec identifies a deployed cluster to protect or unprotect. The
+// manifest is optional and is updated in OutputDirectory when present.
+type ProtectSpec struct {
+	OutputDirectory string
+	Manifest        *util.DeploymentManifest
+
+	DeploymentName string
+	ResourceGroup  string
+}
+
+// Protect puts a CanNotDelete management lock on the cluster's resource
+// group, so that neither azkube nor anything else can delete it.
+func (d *Deployer) Protect(ctx context.Context, spec ProtectSpec) error {
+	if spec.ResourceGroup == "" {
+		return &SpecError{Field: "ResourceGroup", Message: "must be set"}
+	}
+
+	err := d.Client.ProtectResourceGroup(spec.ResourceGroup, spec.DeploymentName)
+	if err != nil {
+		return stepError(ctx, "protect", "lock", err)
+	}
+
+	if spec.Manifest != nil {
+		spec.Manifest.Protected = true
+		return d.saveManifest(ctx, "protect", spec.Manifest, spec.OutputDirectory)
+	}
+	return nil
+}
+
+// Unprotect removes the lock added by Protect. Locks added by other means
+// are left in place and reported.
+func (d *Deployer) Unprotect(ctx context.Context, spec ProtectSpec) error {
+	if spec.ResourceGroup == "" {
+		return &SpecError{Field: "ResourceGroup", Message: "must be set"}
+	}
+
+	managementLocks, err := d.Client.ListResourceGroupLocks(spec.ResourceGroup)
+	if err != nil {
+		return stepError(ctx, "unprotect", "plan", err)
+	}
+
+	found := false
+	for _, lock := range managementLocks {
+		if !lock.IsProtectLock() {
+			log.Warnf("Leaving a management lock that azkube did not add. lock=%q level=%q id=%q", lock.Name, lock.Level, lock.ID)
+			continue
+		}
+		found = true
+		err = d.Client.DeleteLock(lock)
+		if err != nil {
+			return stepError(ctx, "unprotect", "unlock", err)
+		}
+	}
+	if !found {
+		log.Infof("Resource group was not protected by azkube. resourceGroup=%q", spec.ResourceGroup)
+	}
+
+	if spec.Manifest != nil {
+		spec.Manifest.Protected = false
+		return d.saveManifest(ctx, "unprotect", spec.Manifest, spec.OutputDirectory)
+	}
+	return nil
+}
+
+// unlock removes the plan's locks before a forced destroy. Foreign locks are
+// never in them.
+func (d *Deployer) unlock(ctx context.Context, plan *DestroyPlan) error {
+	for _, lock := range plan.Locks {
+		err := d.Client.DeleteLock(lock)
+		if err != nil {
+			return stepError(ctx, "destroy", "unlock", err)
+		}
+	}
+	return nil
+}
+
+// splitLocks separates the locks Destroy may remove, the protect lock and
+// locks on the deployment's own resources, from everybody else's.
+func splitLocks(managementLocks []util.ManagementLock, owned []resources.GenericResource) ([]util.ManagementLock, []util.ManagementLock) {
+	ownedIDs := map[string]bool{}
+	for _, resource := range owned {
+		ownedIDs[strings.ToLower(to.String(resource.ID))] = true
+	}
+
+	ours, foreign := []util.ManagementLock{}, []util.ManagementLock{}
+	for _, lock := range managementLocks {
+		if lock.IsProtectLock() || ownedIDs[strings.ToLower(lock.Scope)] {
+			ours = append(ours, lock)
+		} else {
+			foreign = append(foreign, lock)
+		}
+	}
+	return ours, foreign
+}
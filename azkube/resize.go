@@ -0,0 +1,121 @@
+package azkube
+
+import (
+	"context"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/util"
+	k8s "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// pickInstancesToRoll returns the instances that are kept by a scale and need
+// to be upgraded to the scale set's latest model. When the vm size changes,
+// every kept instance does.
+func pickInstancesToRoll(instances, removed []util.ScaleSetInstance, sizeChanged bool) []util.ScaleSetInstance {
+	removedIDs := map[string]bool{}
+	for _, instance := range removed {
+		removedIDs[instance.InstanceID] = true
+	}
+
+	roll := []util.ScaleSetInstance{}
+	for _, instance := range instances {
+		if removedIDs[instance.InstanceID] {
+			continue
+		}
+		if sizeChanged || !instance.LatestModelApplied {
+			roll = append(roll, instance)
+		}
+	}
+	return roll
+}
+
+// rollInstances moves the plan's outdated instances onto the latest model of
+// the scale set, MaxUnavailable at a time. Each batch is cordoned and
+// drained, upgraded, uncordoned and then waited on until its nodes are Ready.
+func (d *Deployer) rollInstances(ctx context.Context, spec ScaleSpec, plan *ScalePlan) error {
+	maxUnavailable := spec.MaxUnavailable
+	if maxUnavailable <= 0 {
+		maxUnavailable = 1
+	}
+
+	var kubeClient *k8s.Client
+	if !spec.SkipDrain || !spec.SkipValidation {
+		flavorArgs, err := clusterFlavorArgs(spec.Manifest, spec.OutputDirectory)
+		if err != nil {
+			return stepError(ctx, "scale", "roll", err)
+		}
+		kubeClient, err = util.NewKubernetesClient(flavorArgs.MasterFQDN, flavorArgs.CAKeyPair, flavorArgs.ClientKeyPair)
+		if err != nil {
+			return stepError(ctx, "scale", "roll", err)
+		}
+	}
+
+	instances := plan.RollInstances
+	sort.Sort(byInstanceID(instances))
+	for start := 0; start < len(instances); start += maxUnavailable {
+		end := start + maxUnavailable
+		if end > len(instances) {
+			end = len(instances)
+		}
+		batch := instances[start:end]
+
+		instanceIDs := []string{}
+		nodeNames := []string{}
+		for _, instance := range batch {
+			instanceIDs = append(instanceIDs, instance.InstanceID)
+			if instance.NodeName != "" {
+				nodeNames = append(nodeNames, instance.NodeName)
+			}
+		}
+		log.Infof("Rolling instances %d-%d of %d. scaleSet=%q instances=%v", start+1, end, len(instances), plan.ScaleSetName, instanceIDs)
+
+		if !spec.SkipDrain {
+			for _, nodeName := range nodeNames {
+				err := util.DrainNode(kubeClient, nodeName, ctx.Done())
+				if err != nil {
+					uncordonNodes(kubeClient, nodeNames)
+					return stepError(ctx, "scale", "drain", err)
+				}
+			}
+		}
+
+		err := d.Client.UpdateScaleSetInstances(spec.ResourceGroup, plan.ScaleSetName, instanceIDs, ctx.Done())
+		if err != nil {
+			if !spec.SkipDrain {
+				uncordonNodes(kubeClient, nodeNames)
+			}
+			return stepError(ctx, "scale", "update-instances", err)
+		}
+
+		if kubeClient == nil {
+			continue
+		}
+		for _, nodeName := range nodeNames {
+			err = util.UncordonNode(kubeClient, nodeName)
+			if err != nil {
+				return stepError(ctx, "scale", "uncordon", err)
+			}
+		}
+		if !spec.SkipValidation {
+			err = util.WaitForNodesReady(kubeClient, nodeNames, ctx.Done())
+			if err != nil {
+				return stepError(ctx, "scale", util.StepValidation, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// uncordonNodes makes the nodes of a batch that failed to roll schedulable
+// again. Nodes it can't uncordon are logged so they can be uncordoned by
+// hand.
+func uncordonNodes(c *k8s.Client, nodeNames []string) {
+	for _, nodeName := range nodeNames {
+		err := util.UncordonNode(c, nodeName)
+		if err != nil {
+			log.Warnf("Failed to uncordon node, run `kubectl uncordon %s`: %q", nodeName, err)
+		}
+	}
+}
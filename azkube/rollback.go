@@ -0,0 +1,98 @@
+package azkube
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/util"
+)
+
+// RollbackError reports the journal entries that could not be deleted. They
+// stay in the manifest's journal so the rollback can be retried.
+type RollbackError struct {
+	Failed []util.JournalEntry
+	Errs   []error
+}
+
+func (e *RollbackError) Error() string {
+	return fmt.Sprintf("azkube: rollback: failed to delete %d resource(s): %v", len(e.Failed), e.Errs)
+}
+
+// Rollback deletes the resources recorded in the manifest's journal, newest
+// first. Only resources created by azkube are journaled, so anything that
+// existed before the deployment is left alone.
+func (d *Deployer) Rollback(ctx context.Context, manifest *util.DeploymentManifest, outputDirectory string) error {
+	azureClient := d.Client
+	rollbackErr := &RollbackError{}
+
+	for i := len(manifest.Journal) - 1; i >= 0; i-- {
+		entry := manifest.Journal[i]
+		if err := ctx.Err(); err != nil {
+			rollbackErr.Failed = append(rollbackErr.Failed, entry)
+			rollbackErr.Errs = append(rollbackErr.Errs, err)
+			continue
+		}
+
+		log.Warnf("Rolling back. Deleting %s %q.", entry.Kind, entry.ID)
+
+		var err error
+		switch entry.Kind {
+		case util.JournalResourceGroup:
+			_, err = azureClient.GroupsClient.Delete(entry.ID, ctx.Done())
+		case util.JournalRoleAssignment:
+			err = azureClient.DeleteRoleAssignment(entry.ID)
+		case util.JournalServicePrincipal:
+			err = azureClient.DeleteServicePrincipal(entry.ID)
+		case util.JournalApplication:
+			err = azureClient.DeleteApp(entry.ID)
+		default:
+			err = fmt.Errorf("unknown journal entry kind %q", entry.Kind)
+		}
+		if err != nil {
+			log.Errorf("Failed to delete %s %q: %q", entry.Kind, entry.ID, err)
+			rollbackErr.Failed = append(rollbackErr.Failed, entry)
+			rollbackErr.Errs = append(rollbackErr.Errs, err)
+			continue
+		}
+
+		forgetRolledBack(manifest, entry)
+	}
+
+	// keep the failed entries, in their original order
+	manifest.Journal = nil
+	for i := len(rollbackErr.Failed) - 1; i >= 0; i-- {
+		manifest.Journal = append(manifest.Journal, rollbackErr.Failed[i])
+	}
+
+	err := util.SaveManifest(outputDirectory, manifest)
+	if err != nil {
+		return stepError(ctx, "rollback", "manifest", err)
+	}
+
+	if len(rollbackErr.Failed) > 0 {
+		return rollbackErr
+	}
+	log.Infof("Rollback complete.")
+	return nil
+}
+
+// forgetRolledBack clears the completed steps that a deleted resource
+// belonged to, so that a later deploy recreates it.
+func forgetRolledBack(manifest *util.DeploymentManifest, entry util.JournalEntry) {
+	switch entry.Kind {
+	case util.JournalResourceGroup:
+		manifest.UncompleteStep(util.StepResourceGroup)
+		manifest.UncompleteStep(util.StepRoleAssignment)
+		manifest.UncompleteStep(util.StepArmDeployment)
+		manifest.UncompleteStep(util.StepValidation)
+	case util.JournalRoleAssignment:
+		manifest.UncompleteStep(util.StepRoleAssignment)
+	case util.JournalServicePrincipal, util.JournalApplication:
+		manifest.UncompleteStep(util.StepServicePrincipal)
+		manifest.UncompleteStep(util.StepRoleAssignment)
+		manifest.ApplicationID = ""
+		manifest.ServicePrincipalClientID = ""
+		manifest.ServicePrincipalClientSecret = ""
+	}
+}
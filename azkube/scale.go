@@ -0,0 +1,401 @@
+package azkube
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/util"
+	k8s "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// ScaleSpec describes a change to the size of one of a deployment's node
+// pools.
+// Manifest is optional. When present, it supplies the flavor and is updated
+// in OutputDirectory once the scale completes.
+type ScaleSpec struct {
+	OutputDirectory string
+	Manifest        *util.DeploymentManifest
+
+	DeploymentName string
+	ResourceGroup  string
+
+	// Pool is the node pool to scale. The default pool is used if it is empty.
+	Pool string
+
+	// NodeCount is the new capacity of the scale set or, when
+	// RelativeNodeCount is set, the number of nodes to add (or remove, if
+	// negative).
+	NodeCount         int
+	RelativeNodeCount bool
+
+	// NodeSize changes the vm size of the scale set. The current size is kept
+	// if it is empty.
+	NodeSize string
+
+	// SkipDrain removes nodes without cordoning and draining them first. It is
+	// needed when the cluster's client credentials are not in OutputDirectory.
+	SkipDrain bool
+
+	// SkipValidation returns as soon as Azure has scaled the scale set,
+	// without waiting for the nodes to become Ready.
+	SkipValidation bool
+
+	// MaxUnavailable is how many existing nodes are drained and moved onto a
+	// new vm size at a time. It defaults to one.
+	MaxUnavailable int
+
+	// RemoveNodes names the Kubernetes nodes to remove first when the scale
+	// set shrinks. Any further instances to remove are the newest ones.
+	RemoveNodes []string
+}
+
+// ScalePlan is the resolved effect of a ScaleSpec on the live scale set.
+type ScalePlan struct {
+	ResourceGroup    string
+	Pool             string
+	ScaleSetName     string
+	CurrentNodeSize  string
+	CurrentNodeCount int
+	NodeSize         string
+	NodeCount        int
+
+	// RemoveInstances are the instances that will be drained and deleted when
+	// the scale set shrinks.
+	RemoveInstances []util.ScaleSetInstance
+
+	// RollInstances are the remaining instances that still run an older
+	// model of the scale set. They are upgraded in batches once the new
+	// model is deployed.
+	RollInstances []util.ScaleSetInstance
+}
+
+func (plan *ScalePlan) Shrinking() bool {
+	return plan.NodeCount < plan.CurrentNodeCount
+}
+
+// PlanScale reads the current sku of the node scale set and works out the
+// size and capacity the spec would scale it to, without changing anything.
+func (d *Deployer) PlanScale(ctx context.Context, spec ScaleSpec) (*ScalePlan, error) {
+	if spec.DeploymentName == "" {
+		return nil, &SpecError{Field: "DeploymentName", Message: "must be set"}
+	}
+	if spec.ResourceGroup == "" {
+		return nil, &SpecError{Field: "ResourceGroup", Message: "must be set"}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, stepError(ctx, "scale", "plan", err)
+	}
+
+	err := d.ensureRunning(ctx, "scale", spec.ResourceGroup, spec.DeploymentName, spec.Manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := spec.Pool
+	if pool == "" {
+		pool = util.DefaultNodePoolName
+	}
+	if spec.Manifest != nil {
+		if _, ok := util.FindNodePool(spec.Manifest.NodePools, pool); !ok {
+			return nil, &SpecError{Field: "Pool", Message: fmt.Sprintf("deployment has no node pool %q", pool)}
+		}
+	}
+
+	scaleSetName := util.NodeScaleSetName(spec.DeploymentName, pool)
+	currentSize, currentCapacity, err := d.Client.ScaleSetSku(spec.ResourceGroup, scaleSetName)
+	if err != nil {
+		return nil, stepError(ctx, "scale", "plan", err)
+	}
+
+	plan := &ScalePlan{
+		ResourceGroup:    spec.ResourceGroup,
+		Pool:             pool,
+		ScaleSetName:     scaleSetName,
+		CurrentNodeSize:  currentSize,
+		CurrentNodeCount: currentCapacity,
+		NodeSize:         currentSize,
+		NodeCount:        spec.NodeCount,
+	}
+	if spec.NodeSize != "" {
+		plan.NodeSize = spec.NodeSize
+	}
+	if spec.RelativeNodeCount {
+		plan.NodeCount = currentCapacity + spec.NodeCount
+	}
+
+	if plan.NodeCount < 0 {
+		return nil, &SpecError{Field: "NodeCount", Message: "would scale below zero nodes"}
+	}
+
+	instances, err := d.Client.ListScaleSetInstances(spec.ResourceGroup, scaleSetName)
+	if err != nil {
+		return nil, stepError(ctx, "scale", "plan", err)
+	}
+	if plan.Shrinking() {
+		plan.RemoveInstances, err = pickInstancesToRemove(instances, plan.CurrentNodeCount-plan.NodeCount, spec.RemoveNodes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	plan.RollInstances = pickInstancesToRoll(instances, plan.RemoveInstances, plan.NodeSize != plan.CurrentNodeSize)
+
+	return plan, nil
+}
+
+// pickInstancesToRemove chooses the instances of the requested nodes, then
+// the newest instances, which are the ones Azure would have removed itself
+// when lowering capacity.
+func pickInstancesToRemove(instances []util.ScaleSetInstance, count int, nodeNames []string) ([]util.ScaleSetInstance, error) {
+	if len(nodeNames) > count {
+		return nil, &SpecError{Field: "RemoveNodes", Message: fmt.Sprintf("names %d node(s), but only %d are removed", len(nodeNames), count)}
+	}
+
+	sorted := make([]util.ScaleSetInstance, len(instances))
+	copy(sorted, instances)
+	sort.Sort(sort.Reverse(byInstanceID(sorted)))
+
+	picked := []util.ScaleSetInstance{}
+	requested := map[string]bool{}
+	for _, nodeName := range nodeNames {
+		found := false
+		for _, instance := range sorted {
+			if instance.NodeName == strings.ToLower(nodeName) {
+				picked = append(picked, instance)
+				requested[instance.InstanceID] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, &SpecError{Field: "RemoveNodes", Message: fmt.Sprintf("no instance of the scale set runs node %q", nodeName)}
+		}
+	}
+
+	for _, instance := range sorted {
+		if len(picked) >= count {
+			break
+		}
+		if !requested[instance.InstanceID] {
+			picked = append(picked, instance)
+		}
+	}
+	return picked, nil
+}
+
+type byInstanceID []util.ScaleSetInstance
+
+func (s byInstanceID) Len() int      { return len(s) }
+func (s byInstanceID) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byInstanceID) Less(i, j int) bool {
+	a, errA := strconv.Atoi(s[i].InstanceID)
+	b, errB := strconv.Atoi(s[j].InstanceID)
+	if errA != nil || errB != nil {
+		return s[i].InstanceID < s[j].InstanceID
+	}
+	return a < b
+}
+
+// Scale resizes the node scale set as described by spec. It does not ask for
+// confirmation. Use PlanScale first to inspect the change.
+func (d *Deployer) Scale(ctx context.Context, spec ScaleSpec) error {
+	plan, err := d.PlanScale(ctx, spec)
+	if err != nil {
+		return err
+	}
+
+	if plan.NodeCount == plan.CurrentNodeCount && plan.NodeSize == plan.CurrentNodeSize {
+		log.Infof("Scale set already has the requested size and capacity. scaleSet=%q nodeSize=%q nodeCount=%d", plan.ScaleSetName, plan.NodeSize, plan.NodeCount)
+		if len(plan.RollInstances) > 0 {
+			// finish a rollout that was interrupted
+			err = d.rollInstances(ctx, spec, plan)
+			if err != nil {
+				return err
+			}
+		}
+		return d.recordScale(ctx, spec, plan)
+	}
+
+	if len(plan.RemoveInstances) > 0 {
+		err = d.removeInstances(ctx, spec, plan)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(plan.RemoveInstances) == 0 || plan.NodeSize != plan.CurrentNodeSize {
+		err = d.deployScaleTemplate(ctx, spec, plan)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(plan.RollInstances) > 0 {
+		err = d.rollInstances(ctx, spec, plan)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = d.recordScale(ctx, spec, plan)
+	if err != nil {
+		return err
+	}
+
+	if spec.SkipValidation {
+		log.Warnf("Skipping validation of the scaled cluster.")
+		return nil
+	}
+	if spec.Manifest == nil {
+		// the kubeconfig to reach the apiserver comes from the manifest
+		log.Warnf("Skipping validation of the scaled cluster: it needs the deployment's manifest.")
+		return nil
+	}
+	return d.waitForPoolNodes(ctx, "scale", spec.Manifest, spec.OutputDirectory, spec.ResourceGroup, plan.Pool, plan.NodeCount)
+}
+
+func (d *Deployer) deployScaleTemplate(ctx context.Context, spec ScaleSpec, plan *ScalePlan) error {
+	flavor := DefaultFlavor
+	if spec.Manifest != nil {
+		flavor = spec.Manifest.Flavor
+	}
+
+	tags, err := d.scaleSetTags(spec, plan)
+	if err != nil {
+		return stepError(ctx, "scale", "tags", err)
+	}
+	tagsJSON, err := util.TagsJSON(tags)
+	if err != nil {
+		return stepError(ctx, "scale", "tags", err)
+	}
+
+	scaleArgs := struct {
+		ScaleSetName string
+		NodeSize     string
+		NodeCount    int
+		TagsJSON     string
+	}{plan.ScaleSetName, plan.NodeSize, plan.NodeCount, tagsJSON}
+
+	template, err := util.PopulateTemplateMap(flavor, "scale-deploy.in.json", struct{}{})
+	if err != nil {
+		return stepError(ctx, "scale", "template", err)
+	}
+	parameters, err := util.PopulateTemplateMap(flavor, "scale-parameters.in.json", scaleArgs)
+	if err != nil {
+		return stepError(ctx, "scale", "template", err)
+	}
+
+	log.Infof("Scaling deployment. deployment=%q pool=%q nodeCount=%d->%d nodeSize=%q->%q", spec.DeploymentName, plan.Pool, plan.CurrentNodeCount, plan.NodeCount, plan.CurrentNodeSize, plan.NodeSize)
+	_, err = d.Client.DeployTemplate(
+		spec.ResourceGroup,
+		spec.DeploymentName+"-scale",
+		template,
+		parameters,
+		ctx.Done())
+	if deploymentErr, ok := err.(*util.DeploymentError); ok && spec.OutputDirectory != "" {
+		saveErr := util.SaveDeploymentError(spec.OutputDirectory, deploymentErr)
+		if saveErr != nil {
+			log.Warnf("Failed to save deployment error report: %q", saveErr)
+		}
+	}
+	if err != nil {
+		return stepError(ctx, "scale", util.StepArmDeployment, err)
+	}
+
+	return nil
+}
+
+// scaleSetTags returns the tags the scale set keeps after scaling: the ones it
+// already has, with the node pool summary updated to the new size and count.
+func (d *Deployer) scaleSetTags(spec ScaleSpec, plan *ScalePlan) (map[string]string, error) {
+	tags, err := d.Client.ScaleSetTags(spec.ResourceGroup, plan.ScaleSetName)
+	if err != nil {
+		return nil, err
+	}
+
+	var pools []util.NodePool
+	if spec.Manifest != nil {
+		pools = append(pools, spec.Manifest.NodePools...)
+	} else {
+		pools, err = d.Client.ListNodePools(spec.ResourceGroup, spec.DeploymentName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if i, ok := util.FindNodePool(pools, plan.Pool); ok {
+		pools[i].Count = plan.NodeCount
+		pools[i].Size = plan.NodeSize
+	}
+
+	for key, value := range util.NodePoolTags(pools) {
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// removeInstances drains the nodes of the instances being removed, deletes
+// exactly those instances from the scale set, and then deletes their Node
+// objects.
+func (d *Deployer) removeInstances(ctx context.Context, spec ScaleSpec, plan *ScalePlan) error {
+	var kubeClient *k8s.Client
+	if !spec.SkipDrain {
+		var err error
+		kubeClient, err = drainInstances(ctx, "scale", spec.Manifest, spec.OutputDirectory, plan.RemoveInstances)
+		if err != nil {
+			return err
+		}
+	}
+
+	instanceIDs := []string{}
+	for _, instance := range plan.RemoveInstances {
+		instanceIDs = append(instanceIDs, instance.InstanceID)
+	}
+	err := d.Client.DeleteScaleSetInstances(spec.ResourceGroup, plan.ScaleSetName, instanceIDs, ctx.Done())
+	if err != nil {
+		return stepError(ctx, "scale", "delete-instances", err)
+	}
+
+	deleteNodeObjects(kubeClient, plan.RemoveInstances)
+	return nil
+}
+
+func (d *Deployer) recordScale(ctx context.Context, spec ScaleSpec, plan *ScalePlan) error {
+	if spec.Manifest != nil {
+		i, _ := util.FindNodePool(spec.Manifest.NodePools, plan.Pool)
+		spec.Manifest.NodePools[i].Count = plan.NodeCount
+		spec.Manifest.NodePools[i].Size = plan.NodeSize
+	}
+
+	d.tagNodePools(spec.ResourceGroup, spec.DeploymentName, spec.Manifest)
+
+	if spec.Manifest != nil {
+		return d.saveManifest(ctx, "scale", spec.Manifest, spec.OutputDirectory)
+	}
+
+	return nil
+}
+
+// tagNodePools updates the node pool summary in the resource group's tags.
+// Without a manifest, the pools are read from the live scale sets.
+func (d *Deployer) tagNodePools(resourceGroup, deploymentName string, manifest *util.DeploymentManifest) {
+	var pools []util.NodePool
+	if manifest != nil {
+		pools = manifest.NodePools
+	} else {
+		var err error
+		pools, err = d.Client.ListNodePools(resourceGroup, deploymentName)
+		if err != nil {
+			log.Warnf("Failed to list the deployment's node pools: %q", err)
+			return
+		}
+	}
+
+	err := d.Client.TagResourceGroup(resourceGroup, util.NodePoolTags(pools))
+	if err != nil {
+		log.Warnf("Failed to update the resource group's tags: %q", err)
+	}
+}
@@ -0,0 +1,89 @@
+package azkube
+
+import (
+	"context"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/util"
+)
+
+const (
+	preflightClientID     = "00000000-0000-0000-0000-000000000000"
+	preflightClientSecret = "PREFLIGHT-CLIENT-SECRET"
+)
+
+// ValidateTemplateSpec identifies a previously rendered or deployed output
+// directory whose template should be validated.
+type ValidateTemplateSpec struct {
+	OutputDirectory string
+	Manifest        *util.DeploymentManifest
+}
+
+// ValidateTemplate runs ARM's preflight validation against the template and
+// parameters saved in an output directory. A rejected template is reported
+// as a *util.TemplateValidationError.
+func (d *Deployer) ValidateTemplate(ctx context.Context, spec ValidateTemplateSpec) error {
+	if spec.Manifest == nil {
+		return &SpecError{Field: "Manifest", Message: "must be set"}
+	}
+
+	template, parameters, err := util.LoadRenderedTemplate(spec.OutputDirectory)
+	if err != nil {
+		return stepError(ctx, "validate-template", "load", err)
+	}
+
+	manifest := spec.Manifest
+	created, err := d.preflight(ctx, "validate-template", manifest.ResourceGroup, manifest.Location, manifest.DeploymentName, template, parameters)
+	if err != nil {
+		return err
+	}
+
+	// nothing is deployed into the resource group, so don't leave it behind
+	if created {
+		log.Infof("Removing the resource group created for validation. resourceGroup=%q", manifest.ResourceGroup)
+		_, err = d.Client.GroupsClient.Delete(manifest.ResourceGroup, ctx.Done())
+		if err != nil {
+			return stepError(ctx, "validate-template", "cleanup", err)
+		}
+	}
+	return nil
+}
+
+// preflight validates a template before anything is deployed with it. ARM can
+// only validate deployments into an existing resource group, so when the
+// group does not exist yet an empty one is created for the check and removed
+// again if the template is rejected. It reports whether it left a newly
+// created resource group behind.
+func (d *Deployer) preflight(ctx context.Context, op, resourceGroup, location, deploymentName string, template, parameters map[string]interface{}) (bool, error) {
+	azureClient := d.Client
+
+	if err := ctx.Err(); err != nil {
+		return false, stepError(ctx, op, "preflight", err)
+	}
+
+	exists, err := azureClient.ResourceGroupExists(resourceGroup)
+	if err != nil {
+		return false, stepError(ctx, op, "preflight", err)
+	}
+	if !exists {
+		log.Infof("Creating an empty resource group to validate the template in. resourceGroup=%q", resourceGroup)
+		_, err = azureClient.EnsureResourceGroup(resourceGroup, location)
+		if err != nil {
+			return false, stepError(ctx, op, "preflight", err)
+		}
+	}
+
+	err = azureClient.ValidateTemplate(resourceGroup, deploymentName, template, parameters)
+	if err != nil {
+		if !exists {
+			log.Infof("Removing the resource group created for validation. resourceGroup=%q", resourceGroup)
+			_, deleteErr := azureClient.GroupsClient.Delete(resourceGroup, nil)
+			if deleteErr != nil {
+				log.Warnf("Failed to remove the resource group created for validation: %q", deleteErr)
+			}
+		}
+		return false, stepError(ctx, op, "preflight", err)
+	}
+
+	return !exists, nil
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	authLongDescription       = "manage the credentials azkube caches in ~/.azkube"
+	authLoginLongDescription  = "log in with --auth-method and cache the token"
+	authStatusLongDescription = "show the cached credentials for the subscription's tenant, or all of them"
+	authLogoutLongDescription = "remove the cached credentials for the subscription's tenant, or all of them"
+	authListLongDescription   = "list the tenants azkube has cached credentials for"
+
+	// a cached service principal token is used without the secret or
+	// certificate being checked again
+	authTokenCacheNote = "A service principal's cached token is used until it expires, so a rotated or wrong secret is only noticed then. Run `azkube auth logout` to log in again right away."
+)
+
+func NewAuthCmd() *cobra.Command {
+	authCmd := &cobra.Command{
+		Use:   "auth",
+		Short: authLongDescription,
+		Long:  authLongDescription,
+	}
+
+	loginCmd := &cobra.Command{
+		Use:   "login",
+		Short: authLoginLongDescription,
+		Long:  authLoginLongDescription + ".\n\n" + authTokenCacheNote,
+		Run:   runAuthLogin,
+	}
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: authStatusLongDescription,
+		Long:  authStatusLongDescription,
+		Run:   runAuthStatus,
+	}
+
+	logoutCmd := &cobra.Command{
+		Use:   "logout",
+		Short: authLogoutLongDescription,
+		Long:  authLogoutLongDescription + ".\n\n" + authTokenCacheNote,
+		Run:   runAuthLogout,
+	}
+	logoutCmd.Flags().Bool("all", false, "remove the cached credentials of all tenants")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: authListLongDescription,
+		Long:  authListLongDescription,
+		Run:   runAuthList,
+	}
+
+	authCmd.AddCommand(loginCmd)
+	authCmd.AddCommand(statusCmd)
+	authCmd.AddCommand(logoutCmd)
+	authCmd.AddCommand(listCmd)
+
+	return authCmd
+}
+
+func runAuthLogin(cmd *cobra.Command, args []string) {
+	rootArgs := parseRootArgs(cmd, args)
+
+	azureClient, err := getClient(rootArgs)
+	if err != nil {
+		log.Fatalf("Failed to log in: %q", err)
+	}
+	log.Infof("Logged in as %q. tenant=%q", azureClient.Creator(), azureClient.TenantID)
+
+	if rootArgs.AuthMethod == "azure_cli" || rootArgs.AuthMethod == "msi" {
+		log.Infof("Nothing is cached for --auth-method=%s.", rootArgs.AuthMethod)
+		return
+	}
+	printTokenCaches(tokenCachesForTenant(azureClient.TenantID))
+}
+
+func runAuthStatus(cmd *cobra.Command, args []string) {
+	tenantID := subscriptionTenantID()
+	caches := tokenCachesForTenant(tenantID)
+	if len(caches) == 0 {
+		log.Infof("No cached credentials. Run `azkube auth login` to log in.")
+		return
+	}
+	printTokenCaches(caches)
+}
+
+func runAuthLogout(cmd *cobra.Command, args []string) {
+	viper.BindPFlag("all", cmd.Flags().Lookup("all"))
+
+	tenantID := ""
+	if !viper.GetBool("all") {
+		tenantID = subscriptionTenantID()
+		if tenantID == "" {
+			log.Fatalf("--subscription-id or --all must be set.")
+		}
+	}
+
+	paths, err := util.RemoveTokenCaches(tenantID)
+	if err != nil {
+		log.Fatalf("Failed to remove the cached credentials: %q", err)
+	}
+	for _, path := range paths {
+		log.Infof("Removed %q.", path)
+	}
+	if len(paths) == 0 {
+		log.Infof("No cached credentials to remove.")
+	}
+}
+
+func runAuthList(cmd *cobra.Command, args []string) {
+	caches := tokenCachesForTenant("")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TENANT\tCLIENT\tUSER\tEXPIRES")
+	for _, cache := range caches {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			cache.TenantID,
+			tokenCacheClient(cache),
+			cache.User,
+			cache.ExpiresOn.Local().Format(time.RFC3339))
+	}
+	w.Flush()
+}
+
+// subscriptionTenantID looks up the tenant of --subscription-id, if it is set.
+func subscriptionTenantID() string {
+	subscriptionID := viper.GetString("subscription-id")
+	if subscriptionID == "" {
+		return ""
+	}
+
+	environment := parseEnvironment(viper.GetString("azure-environment"), viper.GetString("azure-environment-file"))
+	tenantID, err := util.GetTenantID(environment.Environment, subscriptionID, parseRetryPolicy())
+	if err != nil {
+		log.Fatalf("Failed to find the tenant of subscription %q: %q", subscriptionID, err)
+	}
+	return tenantID
+}
+
+// tokenCachesForTenant lists the token caches of a tenant, or all of them if
+// tenantID is empty.
+func tokenCachesForTenant(tenantID string) []util.CachedToken {
+	caches, err := util.ListTokenCaches()
+	if err != nil {
+		log.Fatalf("Failed to list the cached credentials: %q", err)
+	}
+
+	matching := []util.CachedToken{}
+	for _, cache := range caches {
+		if tenantID == "" || cache.TenantID == tenantID {
+			matching = append(matching, cache)
+		}
+	}
+	return matching
+}
+
+func printTokenCaches(caches []util.CachedToken) {
+	for _, cache := range caches {
+		fmt.Printf("Tenant:  %s\n", cache.TenantID)
+		fmt.Printf("Client:  %s\n", tokenCacheClient(cache))
+		fmt.Printf("User:    %s\n", cache.User)
+		if cache.ExpiresOn.Before(time.Now()) {
+			fmt.Printf("Expires: %s (expired)\n", cache.ExpiresOn.Local().Format(time.RFC3339))
+		} else {
+			fmt.Printf("Expires: %s\n", cache.ExpiresOn.Local().Format(time.RFC3339))
+		}
+		fmt.Printf("Path:    %s\n\n", cache.Path)
+	}
+}
+
+func tokenCacheClient(cache util.CachedToken) string {
+	if cache.ClientID == "" {
+		return "device"
+	}
+	return cache.ClientID
+}
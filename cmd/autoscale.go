@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/azkube"
+	"github.com/colemickens/azkube/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	autoscaleLongDescription = "watch a deployment and resize a node pool for pending pods and underutilized nodes"
+)
+
+type AutoscaleArguments struct {
+	OutputDirectory string
+	DeploymentName  string
+	Pool            string
+	Interval        time.Duration
+	Policy          azkube.AutoscalePolicy
+	SkipDrain       bool
+}
+
+func NewAutoscaleCmd() *cobra.Command {
+	autoscaleCmd := &cobra.Command{
+		Use:   "autoscale",
+		Short: autoscaleLongDescription,
+		Long:  autoscaleLongDescription,
+		Run:   runAutoscale,
+	}
+
+	flags := autoscaleCmd.Flags()
+	flags.String("output-directory", "", "output directory of the deployment (derived from --deployment-name if omitted)")
+	flags.String("deployment-name", "", "deployment name (required unless --output-directory is set)")
+	flags.String("pool", util.DefaultNodePoolName, "node pool to autoscale")
+	flags.Int("min-nodes", 1, "minimum number of nodes in the pool")
+	flags.Int("max-nodes", 0, "maximum number of nodes in the pool (required)")
+	flags.Duration("interval", azkube.DefaultAutoscaleInterval, "how often to check the cluster")
+	flags.Duration("scale-up-cooldown", azkube.DefaultAutoscaleScaleUpCooldown, "time to wait after a change before adding nodes")
+	flags.Duration("scale-down-cooldown", azkube.DefaultAutoscaleScaleDownCooldown, "time to wait after a change before removing a node")
+	flags.Float64("scale-down-utilization", azkube.DefaultAutoscaleScaleDownUtilization, "fraction of requested cpu or memory below which a node is underutilized")
+	flags.Bool("skip-drain", false, "remove nodes without cordoning and draining them first")
+
+	return autoscaleCmd
+}
+
+func parseAutoscaleArgs(cmd *cobra.Command, args []string) (RootArguments, AutoscaleArguments, *util.DeploymentManifest) {
+	flags := cmd.Flags()
+	viper.BindPFlag("output-directory", flags.Lookup("output-directory"))
+	viper.BindPFlag("deployment-name", flags.Lookup("deployment-name"))
+	viper.BindPFlag("pool", flags.Lookup("pool"))
+	viper.BindPFlag("min-nodes", flags.Lookup("min-nodes"))
+	viper.BindPFlag("max-nodes", flags.Lookup("max-nodes"))
+	viper.BindPFlag("interval", flags.Lookup("interval"))
+	viper.BindPFlag("scale-up-cooldown", flags.Lookup("scale-up-cooldown"))
+	viper.BindPFlag("scale-down-cooldown", flags.Lookup("scale-down-cooldown"))
+	viper.BindPFlag("scale-down-utilization", flags.Lookup("scale-down-utilization"))
+	viper.BindPFlag("skip-drain", flags.Lookup("skip-drain"))
+
+	autoscaleArgs := AutoscaleArguments{
+		OutputDirectory: viper.GetString("output-directory"),
+		DeploymentName:  viper.GetString("deployment-name"),
+		Pool:            viper.GetString("pool"),
+		Interval:        viper.GetDuration("interval"),
+		Policy: azkube.AutoscalePolicy{
+			MinNodes:             viper.GetInt("min-nodes"),
+			MaxNodes:             viper.GetInt("max-nodes"),
+			ScaleUpCooldown:      viper.GetDuration("scale-up-cooldown"),
+			ScaleDownCooldown:    viper.GetDuration("scale-down-cooldown"),
+			ScaleDownUtilization: viper.GetFloat64("scale-down-utilization"),
+		},
+		SkipDrain: viper.GetBool("skip-drain"),
+	}
+
+	if autoscaleArgs.Policy.MaxNodes == 0 {
+		log.Fatalf("--max-nodes must be specified.")
+	}
+	if autoscaleArgs.Interval <= 0 {
+		log.Fatalf("--interval must be positive.")
+	}
+
+	var manifest *util.DeploymentManifest
+	autoscaleArgs.OutputDirectory, manifest = resolveManifest(autoscaleArgs.OutputDirectory, autoscaleArgs.DeploymentName)
+	if manifest == nil {
+		log.Fatalf("Autoscaling requires the deployment's output directory.")
+	}
+
+	rootArgs := parseRootArgsWithManifest(cmd, args, manifest)
+
+	return rootArgs, autoscaleArgs, manifest
+}
+
+func runAutoscale(cmd *cobra.Command, args []string) {
+	rootArgs, autoscaleArgs, manifest := parseAutoscaleArgs(cmd, args)
+	azureClient, err := getClient(rootArgs)
+	if err != nil {
+		log.Fatalf("Error occurred while creating the Azure client: %q", err)
+	}
+
+	autoscaler, err := azkube.NewDeployer(azureClient).NewAutoscaler(azkube.AutoscaleSpec{
+		OutputDirectory: autoscaleArgs.OutputDirectory,
+		Manifest:        manifest,
+		Pool:            autoscaleArgs.Pool,
+		Policy:          autoscaleArgs.Policy,
+		SkipDrain:       autoscaleArgs.SkipDrain,
+	})
+	if err != nil {
+		log.Fatalf("Failed to start the autoscaler: %q", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Infof("Stopping the autoscaler.")
+		cancel()
+	}()
+
+	log.Infof("Autoscaling node pool %q of %q between %d and %d nodes.", autoscaleArgs.Pool, manifest.DeploymentName, autoscaleArgs.Policy.MinNodes, autoscaleArgs.Policy.MaxNodes)
+	autoscaler.Run(ctx, autoscaleArgs.Interval)
+}
@@ -1,11 +1,12 @@
 package cmd
 
 import (
+	"fmt"
 	"strings"
 
+	"github.com/colemickens/azkube/azkube"
 	"github.com/colemickens/azkube/util"
 
-	"github.com/Azure/go-autorest/autorest/azure"
 	log "github.com/Sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -17,13 +18,19 @@ const (
 )
 
 type RootArguments struct {
-	Debug           bool
-	SubscriptionID  string
-	AuthMethod      string
-	ClientID        string
-	ClientSecret    string
-	CertificatePath string
-	PrivateKeyPath  string
+	Debug                bool
+	SubscriptionID       string
+	AzureEnvironment     string
+	AzureEnvironmentFile string
+	AuthMethod           string
+	ClientID             string
+	ClientSecret         string
+	CertificatePath      string
+	PrivateKeyPath       string
+	MSIEndpoint          string
+	RetryPolicy          util.RetryPolicy
+
+	Environment util.Environment
 }
 
 func NewRootCmd() *cobra.Command {
@@ -36,11 +43,16 @@ func NewRootCmd() *cobra.Command {
 	pflags.Bool("debug", false, "debug mode, outputs more logging")
 	pflags.String("subscription-id", "", "azure subscription id")
 	pflags.String("tenant-id", "", "azure tenant id")
-	pflags.String("auth-method", "device", "auth method (default:`device`, `client_secret`, `client_certificate`)")
-	pflags.String("client-id", "", "client id (used with --auth-method=[client_secret|client_certificate])")
+	pflags.String("azure-environment", "", "azure environment (default:`AzurePublicCloud`, `AzureChinaCloud`, `AzureGermanCloud`, `AzureUSGovernmentCloud`)")
+	pflags.String("azure-environment-file", "", "path to a json file describing the endpoints of a custom azure environment (overrides --azure-environment)")
+	pflags.String("auth-method", "device", "auth method (default:`device`, `client_secret`, `client_certificate`, `azure_cli`, `msi`)")
+	pflags.String("client-id", "", "client id (used with --auth-method=[client_secret|client_certificate], or to pick a user assigned identity with --auth-method=msi)")
 	pflags.String("client-secret", "", "client secret (used with --auth-mode=client_secret)")
 	pflags.String("certificate-path", "", "path to client certificate (used with --auth-method=client_certificate)")
 	pflags.String("private-key-path", "", "path to private key (used with --auth-method=client_certificate)")
+	pflags.String("msi-endpoint", util.DefaultMSIEndpoint, "managed service identity token endpoint (used with --auth-method=msi)")
+	pflags.Int("max-retries", util.DefaultRetryPolicy.MaxRetries, "number of times a throttled or transiently failed azure request is retried (0 disables retries)")
+	pflags.Duration("retry-max-delay", util.DefaultRetryPolicy.MaxDelay, "longest backoff between retries of an azure request, unless the response asks for longer with Retry-After")
 
 	pflags.MarkDeprecated("tenant-id", "it is now determined automatically from the subscription id")
 
@@ -50,34 +62,68 @@ func NewRootCmd() *cobra.Command {
 	viper.BindPFlag("debug", pflags.Lookup("debug"))
 	viper.BindPFlag("subscription-id", pflags.Lookup("subscription-id"))
 	viper.BindPFlag("tenant-id", pflags.Lookup("tenant-id"))
+	viper.BindPFlag("azure-environment", pflags.Lookup("azure-environment"))
+	viper.BindPFlag("azure-environment-file", pflags.Lookup("azure-environment-file"))
 	viper.BindPFlag("auth-method", pflags.Lookup("auth-method"))
 	viper.BindPFlag("client-id", pflags.Lookup("client-id"))
 	viper.BindPFlag("client-secret", pflags.Lookup("client-secret"))
 	viper.BindPFlag("certificate-path", pflags.Lookup("certificate-path"))
 	viper.BindPFlag("private-key-path", pflags.Lookup("private-key-path"))
+	viper.BindPFlag("msi-endpoint", pflags.Lookup("msi-endpoint"))
+	viper.BindPFlag("max-retries", pflags.Lookup("max-retries"))
+	viper.BindPFlag("retry-max-delay", pflags.Lookup("retry-max-delay"))
 
 	rootCmd.AddCommand(NewDeployCmd())
+	rootCmd.AddCommand(NewRenderCmd())
+	rootCmd.AddCommand(NewValidateTemplateCmd())
 	rootCmd.AddCommand(NewScaleDeploymentCmd())
+	rootCmd.AddCommand(NewPoolCmd())
+	rootCmd.AddCommand(NewAutoscaleCmd())
+	rootCmd.AddCommand(NewStopCmd())
+	rootCmd.AddCommand(NewStartCmd())
+	rootCmd.AddCommand(NewProtectCmd())
+	rootCmd.AddCommand(NewUnprotectCmd())
 	rootCmd.AddCommand(NewDestroyDeploymentCmd())
+	rootCmd.AddCommand(NewDestroyStatusCmd())
+	rootCmd.AddCommand(NewListCmd())
+	rootCmd.AddCommand(NewAuthCmd())
 
 	return rootCmd
 }
 
 func parseRootArgs(cmd *cobra.Command, args []string) RootArguments {
+	return parseRootArgsWithManifest(cmd, args, nil)
+}
+
+func parseRootArgsWithManifest(cmd *cobra.Command, args []string, manifest *util.DeploymentManifest) RootArguments {
 	rootArgs := RootArguments{
-		Debug:           viper.GetBool("debug"),
-		SubscriptionID:  viper.GetString("subscription-id"),
-		AuthMethod:      viper.GetString("auth-method"),
-		ClientID:        viper.GetString("client-id"),
-		ClientSecret:    viper.GetString("client-secret"),
-		CertificatePath: viper.GetString("certificate-path"),
-		PrivateKeyPath:  viper.GetString("private-key-path"),
+		Debug:                viper.GetBool("debug"),
+		SubscriptionID:       viper.GetString("subscription-id"),
+		AzureEnvironment:     viper.GetString("azure-environment"),
+		AzureEnvironmentFile: viper.GetString("azure-environment-file"),
+		AuthMethod:           viper.GetString("auth-method"),
+		ClientID:             viper.GetString("client-id"),
+		ClientSecret:         viper.GetString("client-secret"),
+		CertificatePath:      viper.GetString("certificate-path"),
+		PrivateKeyPath:       viper.GetString("private-key-path"),
+		MSIEndpoint:          viper.GetString("msi-endpoint"),
+		RetryPolicy:          parseRetryPolicy(),
+	}
+
+	if rootArgs.SubscriptionID == "" && manifest != nil {
+		rootArgs.SubscriptionID = manifest.SubscriptionID
 	}
 
 	if rootArgs.SubscriptionID == "" {
 		log.Fatal("--subscription-id must be specified")
 	}
 
+	if rootArgs.AzureEnvironment == "" && rootArgs.AzureEnvironmentFile == "" && manifest != nil && manifest.Environment != nil {
+		rootArgs.Environment = *manifest.Environment
+	} else {
+		rootArgs.Environment = parseEnvironment(rootArgs.AzureEnvironment, rootArgs.AzureEnvironmentFile)
+	}
+
 	if rootArgs.AuthMethod == "client_secret" {
 		if rootArgs.ClientID == "" || rootArgs.ClientSecret == "" {
 			log.Fatal("--client-id and --client-secret must be specified when --auth-method=\"client_secret\".")
@@ -96,23 +142,89 @@ func parseRootArgs(cmd *cobra.Command, args []string) RootArguments {
 	return rootArgs
 }
 
+// parseRetryPolicy reads --max-retries and --retry-max-delay.
+func parseRetryPolicy() util.RetryPolicy {
+	retryPolicy := util.DefaultRetryPolicy
+	retryPolicy.MaxRetries = viper.GetInt("max-retries")
+	retryPolicy.MaxDelay = viper.GetDuration("retry-max-delay")
+
+	if retryPolicy.MaxRetries < 0 {
+		log.Fatal("--max-retries must not be negative.")
+	}
+	if retryPolicy.MaxDelay <= 0 {
+		log.Fatal("--retry-max-delay must be positive.")
+	}
+	return retryPolicy
+}
+
+func parseEnvironment(name, file string) util.Environment {
+	if file != "" {
+		environment, err := util.EnvironmentFromFile(file)
+		if err != nil {
+			log.Fatalf("--azure-environment-file: %q", err)
+		}
+		return environment
+	}
+
+	environment, err := util.EnvironmentFromName(name)
+	if err != nil {
+		log.Fatalf("--azure-environment: %q", err)
+	}
+	return environment
+}
+
 func getClient(rootArgs RootArguments) (*util.AzureClient, error) {
-	azureEnvironment := azure.PublicCloud
-	tenantID, err := util.GetTenantID(azureEnvironment, rootArgs.SubscriptionID)
+	azureEnvironment := rootArgs.Environment
+	log.Debugf("Using azure environment. environment=%q", azureEnvironment.Name)
+	tenantID, err := util.GetTenantID(azureEnvironment.Environment, rootArgs.SubscriptionID, rootArgs.RetryPolicy)
 	if err != nil {
 		return nil, err
 	}
 
 	switch rootArgs.AuthMethod {
 	case "device":
-		return util.NewClientWithDeviceAuth(azureEnvironment, rootArgs.SubscriptionID, tenantID)
+		return util.NewClientWithDeviceAuth(azureEnvironment, rootArgs.SubscriptionID, tenantID, rootArgs.RetryPolicy)
 	case "client_secret":
-		return util.NewClientWithClientSecret(azureEnvironment, rootArgs.SubscriptionID, tenantID, rootArgs.ClientID, rootArgs.ClientSecret)
+		return util.NewClientWithClientSecret(azureEnvironment, rootArgs.SubscriptionID, tenantID, rootArgs.ClientID, rootArgs.ClientSecret, rootArgs.RetryPolicy)
 	case "client_certificate":
-		return util.NewClientWithClientCertificate(azureEnvironment, rootArgs.SubscriptionID, tenantID, rootArgs.ClientID, rootArgs.CertificatePath, rootArgs.PrivateKeyPath)
+		return util.NewClientWithClientCertificate(azureEnvironment, rootArgs.SubscriptionID, tenantID, rootArgs.ClientID, rootArgs.CertificatePath, rootArgs.PrivateKeyPath, rootArgs.RetryPolicy)
+	case "azure_cli":
+		return util.NewClientWithAzureCLI(azureEnvironment, rootArgs.SubscriptionID, tenantID, rootArgs.RetryPolicy)
+	case "msi":
+		return util.NewClientWithMSI(azureEnvironment, rootArgs.SubscriptionID, tenantID, rootArgs.ClientID, rootArgs.MSIEndpoint, rootArgs.RetryPolicy)
 	default:
 		log.Fatalf("--auth-method: ERROR: method unsupported. method=%q.", rootArgs.AuthMethod)
 	}
 
 	return nil, nil // unreachable
 }
+
+// confirmOrExit asks the user to confirm an action and exits if they decline.
+func confirmOrExit(action string) {
+	for {
+		var response string
+		fmt.Printf("Enter 'y' to confirm %s, or 'n' to abort: ", action)
+		fmt.Scanln(&response)
+		if response == "y" {
+			return
+		} else if response == "n" {
+			log.Fatalf("Exit due to user abort")
+		} else {
+			log.Warnf("Unexpected choice: %q. Please enter 'y' or 'n'.", response)
+		}
+	}
+}
+
+// resolveManifest locates the output directory of an existing deployment,
+// either from --output-directory or from --deployment-name, and loads its
+// manifest if one was written. An explicit --output-directory must contain one.
+func resolveManifest(outputDirectory, deploymentName string) (string, *util.DeploymentManifest) {
+	resolvedDirectory, manifest, err := azkube.ResolveManifest(outputDirectory, deploymentName)
+	if err == azkube.ErrManifestNotFound {
+		log.Fatalf("--output-directory: no deployment manifest (%s) found in %q.", util.ManifestFilename, outputDirectory)
+	} else if err != nil {
+		log.Fatalf("Failed to load deployment manifest: %q", err)
+	}
+
+	return resolvedDirectory, manifest
+}
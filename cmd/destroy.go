@@ -1,21 +1,33 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/azkube"
+	"github.com/colemickens/azkube/util"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 const (
-	destroyLongDescription = "destroy a deployment (and its containing resource group)"
+	destroyLongDescription = "destroy a deployment (its resource group and AAD application)"
 )
 
 type DestroyArguments struct {
-	DeploymentName string
-	ResourceGroup  string
-	SkipConfirm    bool
+	OutputDirectory string
+	DeploymentName  string
+	ResourceGroup   string
+	SkipConfirm     bool
+	KeepApplication bool
+	ResourcesOnly   bool
+	ForceUnlock     bool
+	Plan            bool
+	Output          string
+	NoWait          bool
+	Archive         bool
 }
 
 func NewDestroyDeploymentCmd() *cobra.Command {
@@ -27,29 +39,76 @@ func NewDestroyDeploymentCmd() *cobra.Command {
 	}
 	flags := destroyCmd.Flags()
 
-	flags.String("deployment-name", "", "deployment name to destroy (required)")
-	flags.String("resource-group", "", "resource group to destroy (derived from --deployment-name if omitted)")
+	flags.String("output-directory", "", "output directory of the deployment to destroy (derived from --deployment-name if omitted)")
+	flags.String("deployment-name", "", "deployment name to destroy (required unless --output-directory is set)")
+	flags.String("resource-group", "", "resource group to destroy (read from the deployment manifest, or derived from --deployment-name if omitted)")
 	flags.Bool("skip-confirm", false, "skip confimration of resource deletion")
+	flags.Bool("keep-application", false, "don't delete the deployment's AAD application, service principal and role assignment")
+	flags.Bool("force-unlock", false, "remove management locks on the resource group and its resources before deleting them")
+	flags.Bool("resources-only", false, "delete only the deployment's resources (found by their azkube-deployment tag, or the exact names azkube gives them), and the resource group only if azkube created it")
+	flags.Bool("plan", false, "print what would be deleted and exit")
+	flags.StringP("output", "o", "text", "output format of --plan and --no-wait (`text` or `json`)")
+	flags.Bool("no-wait", false, "start the deletion of the resource group and return without waiting for it (see destroy-status)")
+	flags.Bool("archive", false, "archive the output directory to a .tar.gz next to it and remove the directory afterwards")
 
 	return destroyCmd
 }
 
-func parseDestroyArgs(cmd *cobra.Command, args []string) (RootArguments, DestroyArguments) {
-	rootArgs := parseRootArgs(cmd, args)
+func parseDestroyArgs(cmd *cobra.Command, args []string) (RootArguments, DestroyArguments, *util.DeploymentManifest) {
 	flags := cmd.Flags()
 
+	viper.BindPFlag("output-directory", flags.Lookup("output-directory"))
 	viper.BindPFlag("deployment-name", flags.Lookup("deployment-name"))
 	viper.BindPFlag("resource-group", flags.Lookup("resource-group"))
 	viper.BindPFlag("skip-confirm", flags.Lookup("skip-confirm"))
+	viper.BindPFlag("keep-application", flags.Lookup("keep-application"))
+	viper.BindPFlag("resources-only", flags.Lookup("resources-only"))
+	viper.BindPFlag("force-unlock", flags.Lookup("force-unlock"))
+	viper.BindPFlag("plan", flags.Lookup("plan"))
+	viper.BindPFlag("output", flags.Lookup("output"))
+	viper.BindPFlag("no-wait", flags.Lookup("no-wait"))
+	viper.BindPFlag("archive", flags.Lookup("archive"))
 
 	destroyArgs := DestroyArguments{
-		DeploymentName: viper.GetString("deployment-name"),
-		ResourceGroup:  viper.GetString("resource-group"),
-		SkipConfirm:    viper.GetBool("skip-confirm"),
+		OutputDirectory: viper.GetString("output-directory"),
+		DeploymentName:  viper.GetString("deployment-name"),
+		ResourceGroup:   viper.GetString("resource-group"),
+		SkipConfirm:     viper.GetBool("skip-confirm"),
+		KeepApplication: viper.GetBool("keep-application"),
+		ResourcesOnly:   viper.GetBool("resources-only"),
+		ForceUnlock:     viper.GetBool("force-unlock"),
+		Plan:            viper.GetBool("plan"),
+		Output:          viper.GetString("output"),
+		NoWait:          viper.GetBool("no-wait"),
+		Archive:         viper.GetBool("archive"),
+	}
+
+	if destroyArgs.Output != "text" && destroyArgs.Output != "json" {
+		log.Fatalf("--output: ERROR: format unsupported. format=%q.", destroyArgs.Output)
+	}
+	if destroyArgs.NoWait && destroyArgs.ResourcesOnly {
+		log.Fatalf("--no-wait can't be combined with --resources-only.")
+	}
+	if destroyArgs.NoWait && destroyArgs.Archive {
+		log.Fatalf("--no-wait can't be combined with --archive, which would archive the operation that destroy-status follows.")
+	}
+
+	var manifest *util.DeploymentManifest
+	destroyArgs.OutputDirectory, manifest = resolveManifest(destroyArgs.OutputDirectory, destroyArgs.DeploymentName)
+
+	rootArgs := parseRootArgsWithManifest(cmd, args, manifest)
+
+	if manifest != nil {
+		if destroyArgs.DeploymentName == "" {
+			destroyArgs.DeploymentName = manifest.DeploymentName
+		}
+		if destroyArgs.ResourceGroup == "" {
+			destroyArgs.ResourceGroup = manifest.ResourceGroup
+		}
 	}
 
 	if destroyArgs.DeploymentName == "" {
-		log.Fatalf("--deployment-name must be set.")
+		log.Fatalf("--deployment-name or --output-directory must be set.")
 	}
 
 	if destroyArgs.ResourceGroup == "" {
@@ -57,50 +116,149 @@ func parseDestroyArgs(cmd *cobra.Command, args []string) (RootArguments, Destroy
 		log.Warnf("--resource-group is unset. deriving it from --deployment-name.")
 	}
 
-	if destroyArgs.SkipConfirm {
+	if destroyArgs.Archive && manifest == nil {
+		log.Fatalf("--archive requires the deployment's output directory.")
+	}
+
+	if destroyArgs.SkipConfirm && !destroyArgs.Plan {
 		log.Warnf("--skip-confirm is set. Will NOT confirm deletion!")
 	}
 
-	return rootArgs, destroyArgs
+	return rootArgs, destroyArgs, manifest
 }
 
 func runDestroy(cmd *cobra.Command, args []string) {
-	rootArgs, destroyArgs := parseDestroyArgs(cmd, args)
+	rootArgs, destroyArgs, manifest := parseDestroyArgs(cmd, args)
 
 	azureClient, err := getClient(rootArgs)
 	if err != nil {
 		log.Fatalf("Error occurred while creating the Azure client: %q", err)
 	}
 
-	resources, err := azureClient.ListResources(destroyArgs.ResourceGroup)
+	deployer := azkube.NewDeployer(azureClient)
+	destroySpec := azkube.DestroySpec{
+		OutputDirectory: destroyArgs.OutputDirectory,
+		Manifest:        manifest,
+		DeploymentName:  destroyArgs.DeploymentName,
+		ResourceGroup:   destroyArgs.ResourceGroup,
+		KeepApplication: destroyArgs.KeepApplication,
+		ResourcesOnly:   destroyArgs.ResourcesOnly,
+		ForceUnlock:     destroyArgs.ForceUnlock,
+		NoWait:          destroyArgs.NoWait,
+	}
+
+	plan, err := deployer.PlanDestroy(context.Background(), destroySpec)
 	if err != nil {
 		log.Fatalf("Failed to list resources to destroy: %q", err)
 	}
 
-	for _, resource := range *resources {
-		log.Warnf("Going to delete: %s (%s)", *resource.Name, *resource.Type)
+	if destroyArgs.Plan {
+		if destroyArgs.Output == "json" {
+			printJSON(plan)
+		} else {
+			logDestroyPlan(plan, destroyArgs)
+		}
+		return
+	}
+
+	if len(plan.ForeignLocks) > 0 && !plan.ResourcesOnly {
+		logDestroyPlan(plan, destroyArgs)
+		log.Fatalf("Resource group %q is protected by %d management lock(s) that don't belong to %q. Remove them yourself, or use --resources-only.", plan.ResourceGroup, len(plan.ForeignLocks), destroyArgs.DeploymentName)
 	}
 
-	log.Warnf("Going to delete a total of: %d item(s)", len(*resources))
-	if !destroyArgs.SkipConfirm {
-		for {
-			var response string
-			fmt.Printf("Enter 'y' to confirm deletion, or 'n' to abort: ")
-			fmt.Scanln(&response)
-			if response == "y" {
-				break
-			} else if response == "n" {
-				log.Fatalf("Exit due to user abort")
+	if len(plan.Locks) > 0 && !destroyArgs.ForceUnlock {
+		logDestroyPlan(plan, destroyArgs)
+		log.Fatalf("Resource group %q is protected by %d management lock(s). Run `azkube unprotect` or pass --force-unlock to remove them.", plan.ResourceGroup, len(plan.Locks))
+	}
+
+	total := logDestroyPlan(plan, destroyArgs)
+	if total == 0 {
+		log.Infof("Nothing to delete.")
+	} else {
+		if !destroyArgs.SkipConfirm {
+			confirmOrExit("deletion")
+		}
+
+		operation, err := deployer.Destroy(context.Background(), destroySpec)
+		if err != nil {
+			log.Fatalf("Failed to destroy the deployment: %q", err)
+		}
+
+		if operation != nil {
+			if destroyArgs.Output == "json" {
+				printJSON(operation)
 			} else {
-				log.Warnf("Unexpected choice: %q. Please enter 'y' or 'n'.", response)
+				log.Infof("Deletion of resource group %q started. Follow it with `azkube destroy-status --deployment-name %s`.", operation.ResourceGroup, destroyArgs.DeploymentName)
+				log.Infof("Status url: %s", operation.StatusURL)
 			}
 		}
 	}
 
-	log.Infof("Starting the deletion of resource group. resourceGroup=%q", destroyArgs.ResourceGroup)
-	_, err = azureClient.GroupsClient.Delete(destroyArgs.ResourceGroup, nil)
+	if destroyArgs.Archive {
+		archivePath := destroyArgs.OutputDirectory + ".tar.gz"
+		err = util.ArchiveDirectory(destroyArgs.OutputDirectory, archivePath)
+		if err != nil {
+			log.Fatalf("Failed to archive the output directory: %q", err)
+		}
+		log.Infof("Archived the output directory to %q.", archivePath)
+	}
+}
+
+// logDestroyPlan lists what the plan would delete and returns the number of
+// items.
+func logDestroyPlan(plan *azkube.DestroyPlan, destroyArgs DestroyArguments) int {
+	for _, lock := range plan.Locks {
+		log.Warnf("Management lock: %s (%s) %s", lock.Name, lock.Level, lock.ID)
+	}
+	for _, lock := range plan.ForeignLocks {
+		log.Warnf("Management lock that azkube will leave: %s (%s) %s", lock.Name, lock.Level, lock.ID)
+	}
+	if len(plan.Locks) > 0 && destroyArgs.ForceUnlock {
+		log.Warnf("--force-unlock is set. Going to remove %d management lock(s) first.", len(plan.Locks))
+	}
+
+	for _, resource := range plan.Resources {
+		log.Warnf("Going to delete: %s (%s)", *resource.Name, *resource.Type)
+	}
+
+	if !plan.ResourcesOnly && plan.ForeignResources > 0 {
+		log.Warnf("%d of these resources don't belong to %q. Use --resources-only to keep them.", plan.ForeignResources, destroyArgs.DeploymentName)
+	}
+
+	total := len(plan.Resources)
+	if plan.DeleteResourceGroup {
+		if plan.ResourcesOnly {
+			log.Warnf("Going to delete: %s (resource group, if it is empty afterwards)", plan.ResourceGroup)
+		} else {
+			log.Warnf("Going to delete: %s (resource group)", plan.ResourceGroup)
+		}
+		total++
+	} else if plan.ResourceGroupExists {
+		log.Infof("Keeping resource group %q, azkube did not create it.", plan.ResourceGroup)
+	}
+	for _, roleAssignmentID := range plan.RoleAssignmentIDs {
+		log.Warnf("Going to delete: %s (role assignment)", roleAssignmentID)
+		total++
+	}
+	if plan.ServicePrincipal != nil {
+		log.Warnf("Going to delete: %s (service principal)", plan.ServicePrincipal.ObjectID)
+		total++
+	}
+	if plan.Application != nil {
+		log.Warnf("Going to delete: %s %s (application)", plan.Application.DisplayName, plan.Application.ApplicationID)
+		total++
+	}
+
+	if total > 0 {
+		log.Warnf("Going to delete a total of: %d item(s)", total)
+	}
+	return total
+}
+
+func printJSON(v interface{}) {
+	contents, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
-		log.Fatalf("Failed to destroy the resource group: %q", err)
+		log.Fatalf("Failed to encode output: %q", err)
 	}
-	log.Infof("Finished the deletion of resource group. resourceGroup=%q", destroyArgs.ResourceGroup)
+	fmt.Println(string(contents))
 }
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/azkube"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	destroyStatusLongDescription = "report the progress of a deployment destroyed with --no-wait"
+
+	destroyStatusPollInterval = 15 * time.Second
+)
+
+type DestroyStatusArguments struct {
+	OutputDirectory string
+	DeploymentName  string
+	ResourceGroup   string
+	StatusURL       string
+	Wait            bool
+	Output          string
+}
+
+func NewDestroyStatusCmd() *cobra.Command {
+	var destroyStatusCmd = &cobra.Command{
+		Use:   "destroy-status",
+		Short: destroyStatusLongDescription,
+		Long:  destroyStatusLongDescription,
+		Run:   runDestroyStatus,
+	}
+	flags := destroyStatusCmd.Flags()
+
+	flags.String("output-directory", "", "output directory of the destroyed deployment (derived from --deployment-name if omitted)")
+	flags.String("deployment-name", "", "deployment name of the destroyed deployment")
+	flags.String("resource-group", "", "resource group being deleted (read from the saved operation if omitted)")
+	flags.String("status-url", "", "status url printed by destroy --no-wait (read from the saved operation if omitted)")
+	flags.Bool("wait", false, "poll until the resource group is deleted")
+	flags.StringP("output", "o", "text", "output format (`text` or `json`)")
+
+	return destroyStatusCmd
+}
+
+func parseDestroyStatusArgs(cmd *cobra.Command, args []string) (RootArguments, DestroyStatusArguments, *azkube.DestroyOperation) {
+	flags := cmd.Flags()
+
+	viper.BindPFlag("output-directory", flags.Lookup("output-directory"))
+	viper.BindPFlag("deployment-name", flags.Lookup("deployment-name"))
+	viper.BindPFlag("resource-group", flags.Lookup("resource-group"))
+	viper.BindPFlag("status-url", flags.Lookup("status-url"))
+	viper.BindPFlag("wait", flags.Lookup("wait"))
+	viper.BindPFlag("output", flags.Lookup("output"))
+
+	statusArgs := DestroyStatusArguments{
+		OutputDirectory: viper.GetString("output-directory"),
+		DeploymentName:  viper.GetString("deployment-name"),
+		ResourceGroup:   viper.GetString("resource-group"),
+		StatusURL:       viper.GetString("status-url"),
+		Wait:            viper.GetBool("wait"),
+		Output:          viper.GetString("output"),
+	}
+
+	if statusArgs.Output != "text" && statusArgs.Output != "json" {
+		log.Fatalf("--output: ERROR: format unsupported. format=%q.", statusArgs.Output)
+	}
+
+	operation := &azkube.DestroyOperation{}
+	var err error
+	if statusArgs.OutputDirectory == "" && statusArgs.DeploymentName != "" {
+		statusArgs.OutputDirectory, err = azkube.DefaultOutputDirectory(statusArgs.DeploymentName)
+		if err != nil {
+			log.Fatalf("Failed to derive the output directory: %q", err)
+		}
+	}
+	if statusArgs.OutputDirectory != "" {
+		saved, err := azkube.LoadDestroyOperation(statusArgs.OutputDirectory)
+		if err != nil {
+			log.Fatalf("Failed to load the destroy operation: %q", err)
+		}
+		if saved != nil {
+			operation = saved
+		}
+	}
+
+	if statusArgs.ResourceGroup != "" {
+		operation.ResourceGroup = statusArgs.ResourceGroup
+	}
+	if statusArgs.StatusURL != "" {
+		operation.StatusURL = statusArgs.StatusURL
+	}
+	if operation.ResourceGroup == "" {
+		operation.ResourceGroup = statusArgs.DeploymentName
+	}
+	if operation.ResourceGroup == "" {
+		log.Fatalf("--output-directory, --deployment-name or --resource-group must be set.")
+	}
+
+	if viper.GetString("subscription-id") == "" && operation.SubscriptionID != "" {
+		viper.Set("subscription-id", operation.SubscriptionID)
+	}
+	rootArgs := parseRootArgs(cmd, args)
+
+	return rootArgs, statusArgs, operation
+}
+
+func runDestroyStatus(cmd *cobra.Command, args []string) {
+	rootArgs, statusArgs, operation := parseDestroyStatusArgs(cmd, args)
+
+	azureClient, err := getClient(rootArgs)
+	if err != nil {
+		log.Fatalf("Error occurred while creating the Azure client: %q", err)
+	}
+
+	deployer := azkube.NewDeployer(azureClient)
+	for {
+		status, err := deployer.DestroyStatus(context.Background(), operation)
+		if err != nil {
+			log.Fatalf("Failed to get the status of the deletion: %q", err)
+		}
+
+		if !statusArgs.Wait || status == azkube.DestroyStatusDeleted {
+			if statusArgs.Output == "json" {
+				printJSON(map[string]interface{}{
+					"resourceGroup": operation.ResourceGroup,
+					"status":        status,
+				})
+			} else {
+				log.Infof("Resource group %q: %s", operation.ResourceGroup, status)
+			}
+			return
+		}
+
+		log.Infof("Resource group %q is still being deleted.", operation.ResourceGroup)
+		time.Sleep(destroyStatusPollInterval)
+	}
+}
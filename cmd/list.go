@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/azkube"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	listLongDescription = "list the clusters deployed by azkube in a subscription"
+)
+
+type ListArguments struct {
+	Output string
+}
+
+func NewListCmd() *cobra.Command {
+	var listCmd = &cobra.Command{
+		Use:   "list",
+		Short: listLongDescription,
+		Long:  listLongDescription,
+		Run:   runList,
+	}
+
+	flags := listCmd.Flags()
+	flags.StringP("output", "o", "table", "output format (`table` or `json`)")
+
+	return listCmd
+}
+
+func parseListArgs(cmd *cobra.Command, args []string) (RootArguments, ListArguments) {
+	flags := cmd.Flags()
+	viper.BindPFlag("output", flags.Lookup("output"))
+
+	listArgs := ListArguments{
+		Output: viper.GetString("output"),
+	}
+
+	if listArgs.Output != "table" && listArgs.Output != "json" {
+		log.Fatalf("--output: ERROR: format unsupported. format=%q.", listArgs.Output)
+	}
+
+	return parseRootArgs(cmd, args), listArgs
+}
+
+func runList(cmd *cobra.Command, args []string) {
+	rootArgs, listArgs := parseListArgs(cmd, args)
+
+	azureClient, err := getClient(rootArgs)
+	if err != nil {
+		log.Fatalf("Error occurred while creating the Azure client: %q", err)
+	}
+
+	clusters, err := azkube.NewDeployer(azureClient).List(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to list clusters: %q", err)
+	}
+
+	if listArgs.Output == "json" {
+		contents, err := json.MarshalIndent(clusters, "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to encode clusters: %q", err)
+		}
+		fmt.Println(string(contents))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tRESOURCE GROUP\tLOCATION\tMASTER\tNODE SIZE\tNODES\tAGE")
+	for _, cluster := range clusters {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			cluster.DeploymentName,
+			cluster.ResourceGroup,
+			cluster.Location,
+			cluster.MasterFQDN,
+			cluster.NodeSize,
+			cluster.NodeCount,
+			formatAge(cluster.Created))
+	}
+	w.Flush()
+}
+
+func formatAge(created time.Time) string {
+	if created.IsZero() {
+		return "unknown"
+	}
+
+	age := time.Since(created)
+	switch {
+	case age >= 48*time.Hour:
+		return fmt.Sprintf("%dd", int(age.Hours()/24))
+	case age >= time.Hour:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	}
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/azkube"
+	"github.com/colemickens/azkube/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	poolLongDescription       = "manage a deployment's node pools"
+	poolAddLongDescription    = "add a node pool to a deployment"
+	poolRemoveLongDescription = "drain and remove a node pool from a deployment"
+)
+
+type PoolArguments struct {
+	OutputDirectory string
+	DeploymentName  string
+	ResourceGroup   string
+	Pool            util.NodePool
+	SkipDrain       bool
+	SkipValidation  bool
+	SkipConfirm     bool
+}
+
+func NewPoolCmd() *cobra.Command {
+	poolCmd := &cobra.Command{
+		Use:   "pool",
+		Short: poolLongDescription,
+		Long:  poolLongDescription,
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add",
+		Short: poolAddLongDescription,
+		Long:  poolAddLongDescription,
+		Run:   runPoolAdd,
+	}
+	flags := addCmd.Flags()
+	addPoolTargetFlags(addCmd)
+	flags.String("size", "Standard_A1", "size of the pool's virtual machines")
+	flags.Int("count", 1, "number of virtual machines in the pool")
+	flags.StringSlice("labels", []string{}, "comma delimited list of key=value labels for the pool's nodes")
+	flags.StringSlice("taints", []string{}, "comma delimited list of key=value:effect taints for the pool's nodes (requires Kubernetes v1.6 or later, whose kubelet supports --register-with-taints)")
+	flags.Bool("skip-validation", false, "don't wait for the pool's nodes to become ready")
+
+	removeCmd := &cobra.Command{
+		Use:   "remove",
+		Short: poolRemoveLongDescription,
+		Long:  poolRemoveLongDescription,
+		Run:   runPoolRemove,
+	}
+	flags = removeCmd.Flags()
+	addPoolTargetFlags(removeCmd)
+	flags.Bool("skip-drain", false, "remove the pool's nodes without cordoning and draining them first")
+	flags.Bool("skip-confirm", false, "skip confirmation of the pool's removal")
+
+	poolCmd.AddCommand(addCmd)
+	poolCmd.AddCommand(removeCmd)
+
+	return poolCmd
+}
+
+func addPoolTargetFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.String("output-directory", "", "output directory of the deployment (derived from --deployment-name if omitted)")
+	flags.String("deployment-name", "", "deployment name (required unless --output-directory is set)")
+	flags.String("resource-group", "", "resource group name (read from the deployment manifest, or derived from --deployment-name if unset)")
+	flags.String("name", "", "name of the node pool (required)")
+}
+
+func parsePoolArgs(cmd *cobra.Command, args []string) (RootArguments, PoolArguments, *util.DeploymentManifest) {
+	flags := cmd.Flags()
+	viper.BindPFlag("output-directory", flags.Lookup("output-directory"))
+	viper.BindPFlag("deployment-name", flags.Lookup("deployment-name"))
+	viper.BindPFlag("resource-group", flags.Lookup("resource-group"))
+	viper.BindPFlag("name", flags.Lookup("name"))
+
+	poolArgs := PoolArguments{
+		OutputDirectory: viper.GetString("output-directory"),
+		DeploymentName:  viper.GetString("deployment-name"),
+		ResourceGroup:   viper.GetString("resource-group"),
+		Pool:            util.NodePool{Name: viper.GetString("name")},
+	}
+
+	if poolArgs.Pool.Name == "" {
+		log.Fatalf("--name must be specified.")
+	}
+
+	var manifest *util.DeploymentManifest
+	poolArgs.OutputDirectory, manifest = resolveManifest(poolArgs.OutputDirectory, poolArgs.DeploymentName)
+
+	rootArgs := parseRootArgsWithManifest(cmd, args, manifest)
+
+	if manifest != nil {
+		if poolArgs.DeploymentName == "" {
+			poolArgs.DeploymentName = manifest.DeploymentName
+		}
+		if poolArgs.ResourceGroup == "" {
+			poolArgs.ResourceGroup = manifest.ResourceGroup
+		}
+	}
+
+	if poolArgs.DeploymentName == "" {
+		log.Fatalf("--deployment-name or --output-directory must be set!")
+	}
+
+	if poolArgs.ResourceGroup == "" {
+		poolArgs.ResourceGroup = poolArgs.DeploymentName
+		log.Warnf("--resource-group is unset. deriving it from --deployment-name: %q.", poolArgs.ResourceGroup)
+	}
+
+	return rootArgs, poolArgs, manifest
+}
+
+func parsePoolAddArgs(cmd *cobra.Command, args []string) (RootArguments, PoolArguments, *util.DeploymentManifest) {
+	flags := cmd.Flags()
+	viper.BindPFlag("size", flags.Lookup("size"))
+	viper.BindPFlag("count", flags.Lookup("count"))
+	viper.BindPFlag("labels", flags.Lookup("labels"))
+	viper.BindPFlag("taints", flags.Lookup("taints"))
+	viper.BindPFlag("skip-validation", flags.Lookup("skip-validation"))
+
+	rootArgs, poolArgs, manifest := parsePoolArgs(cmd, args)
+	if manifest == nil {
+		log.Fatalf("Adding a node pool requires the deployment's output directory.")
+	}
+
+	poolArgs.Pool.Size = viper.GetString("size")
+	poolArgs.Pool.Count = viper.GetInt("count")
+	poolArgs.Pool.Taints = viper.GetStringSlice("taints")
+	poolArgs.SkipValidation = viper.GetBool("skip-validation")
+
+	labels := viper.GetStringSlice("labels")
+	if len(labels) > 0 {
+		poolArgs.Pool.Labels = map[string]string{}
+	}
+	for _, label := range labels {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 {
+			log.Fatalf("--labels: expected key=value: %q", label)
+		}
+		poolArgs.Pool.Labels[parts[0]] = parts[1]
+	}
+
+	err := poolArgs.Pool.Validate()
+	if err != nil {
+		log.Fatalf("Invalid node pool: %q", err)
+	}
+
+	return rootArgs, poolArgs, manifest
+}
+
+func parsePoolRemoveArgs(cmd *cobra.Command, args []string) (RootArguments, PoolArguments, *util.DeploymentManifest) {
+	flags := cmd.Flags()
+	viper.BindPFlag("skip-drain", flags.Lookup("skip-drain"))
+	viper.BindPFlag("skip-confirm", flags.Lookup("skip-confirm"))
+
+	rootArgs, poolArgs, manifest := parsePoolArgs(cmd, args)
+	poolArgs.SkipDrain = viper.GetBool("skip-drain")
+	poolArgs.SkipConfirm = viper.GetBool("skip-confirm")
+
+	return rootArgs, poolArgs, manifest
+}
+
+func runPoolAdd(cmd *cobra.Command, args []string) {
+	rootArgs, poolArgs, manifest := parsePoolAddArgs(cmd, args)
+	azureClient, err := getClient(rootArgs)
+	if err != nil {
+		log.Fatalf("Error occurred while creating the Azure client: %q", err)
+	}
+
+	err = azkube.NewDeployer(azureClient).AddPool(context.Background(), azkube.NodePoolSpec{
+		OutputDirectory: poolArgs.OutputDirectory,
+		Manifest:        manifest,
+		DeploymentName:  poolArgs.DeploymentName,
+		ResourceGroup:   poolArgs.ResourceGroup,
+		Pool:            poolArgs.Pool,
+		SkipValidation:  poolArgs.SkipValidation,
+	})
+	if err != nil {
+		log.Fatalf("Failed to add the node pool: %q", err)
+	}
+
+	log.Infof("Node pool added: %q", poolArgs.Pool.Name)
+}
+
+func runPoolRemove(cmd *cobra.Command, args []string) {
+	rootArgs, poolArgs, manifest := parsePoolRemoveArgs(cmd, args)
+	azureClient, err := getClient(rootArgs)
+	if err != nil {
+		log.Fatalf("Error occurred while creating the Azure client: %q", err)
+	}
+
+	log.Warnf("Going to drain and delete node pool %q of %q.", poolArgs.Pool.Name, poolArgs.DeploymentName)
+	if poolArgs.SkipDrain {
+		log.Warnf("--skip-drain is set. Pods on these nodes will not be evicted first.")
+	}
+	if !poolArgs.SkipConfirm {
+		confirmOrExit("removal")
+	}
+
+	err = azkube.NewDeployer(azureClient).RemovePool(context.Background(), azkube.NodePoolSpec{
+		OutputDirectory: poolArgs.OutputDirectory,
+		Manifest:        manifest,
+		DeploymentName:  poolArgs.DeploymentName,
+		ResourceGroup:   poolArgs.ResourceGroup,
+		Pool:            poolArgs.Pool,
+		SkipDrain:       poolArgs.SkipDrain,
+	})
+	if err != nil {
+		log.Fatalf("Failed to remove the node pool: %q", err)
+	}
+
+	log.Infof("Node pool removed: %q", poolArgs.Pool.Name)
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/azkube"
+	"github.com/colemickens/azkube/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	stopLongDescription  = "deallocate a deployment's vms, keeping its disks, ips and resource group"
+	startLongDescription = "start a stopped deployment and wait for it to become healthy"
+)
+
+type PowerArguments struct {
+	OutputDirectory string
+	DeploymentName  string
+	ResourceGroup   string
+	SkipConfirm     bool
+	SkipValidation  bool
+}
+
+func NewStopCmd() *cobra.Command {
+	stopCmd := &cobra.Command{
+		Use:   "stop",
+		Short: stopLongDescription,
+		Long:  stopLongDescription,
+		Run:   runStop,
+	}
+	addPowerTargetFlags(stopCmd)
+	stopCmd.Flags().Bool("skip-confirm", false, "skip confirmation of stopping the cluster")
+
+	return stopCmd
+}
+
+func NewStartCmd() *cobra.Command {
+	startCmd := &cobra.Command{
+		Use:   "start",
+		Short: startLongDescription,
+		Long:  startLongDescription,
+		Run:   runStart,
+	}
+	addPowerTargetFlags(startCmd)
+	startCmd.Flags().Bool("skip-validation", false, "don't wait for the cluster to become healthy")
+
+	return startCmd
+}
+
+func addPowerTargetFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.String("output-directory", "", "output directory of the deployment (derived from --deployment-name if omitted)")
+	flags.String("deployment-name", "", "deployment name (required unless --output-directory is set)")
+	flags.String("resource-group", "", "resource group name (read from the deployment manifest, or derived from --deployment-name if unset)")
+}
+
+func parsePowerArgs(cmd *cobra.Command, args []string) (RootArguments, PowerArguments, *util.DeploymentManifest) {
+	flags := cmd.Flags()
+	viper.BindPFlag("output-directory", flags.Lookup("output-directory"))
+	viper.BindPFlag("deployment-name", flags.Lookup("deployment-name"))
+	viper.BindPFlag("resource-group", flags.Lookup("resource-group"))
+	viper.BindPFlag("skip-confirm", flags.Lookup("skip-confirm"))
+	viper.BindPFlag("skip-validation", flags.Lookup("skip-validation"))
+
+	powerArgs := PowerArguments{
+		OutputDirectory: viper.GetString("output-directory"),
+		DeploymentName:  viper.GetString("deployment-name"),
+		ResourceGroup:   viper.GetString("resource-group"),
+		SkipConfirm:     viper.GetBool("skip-confirm"),
+		SkipValidation:  viper.GetBool("skip-validation"),
+	}
+
+	var manifest *util.DeploymentManifest
+	powerArgs.OutputDirectory, manifest = resolveManifest(powerArgs.OutputDirectory, powerArgs.DeploymentName)
+
+	rootArgs := parseRootArgsWithManifest(cmd, args, manifest)
+
+	if manifest != nil {
+		if powerArgs.DeploymentName == "" {
+			powerArgs.DeploymentName = manifest.DeploymentName
+		}
+		if powerArgs.ResourceGroup == "" {
+			powerArgs.ResourceGroup = manifest.ResourceGroup
+		}
+	}
+
+	if powerArgs.DeploymentName == "" {
+		log.Fatalf("--deployment-name or --output-directory must be set!")
+	}
+
+	if powerArgs.ResourceGroup == "" {
+		powerArgs.ResourceGroup = powerArgs.DeploymentName
+		log.Warnf("--resource-group is unset. deriving it from --deployment-name: %q.", powerArgs.ResourceGroup)
+	}
+
+	return rootArgs, powerArgs, manifest
+}
+
+func runStop(cmd *cobra.Command, args []string) {
+	rootArgs, powerArgs, manifest := parsePowerArgs(cmd, args)
+	azureClient, err := getClient(rootArgs)
+	if err != nil {
+		log.Fatalf("Error occurred while creating the Azure client: %q", err)
+	}
+
+	log.Warnf("Going to deallocate the master and all nodes of %q.", powerArgs.DeploymentName)
+	if !powerArgs.SkipConfirm {
+		confirmOrExit("stopping the cluster")
+	}
+
+	err = azkube.NewDeployer(azureClient).Stop(context.Background(), azkube.PowerSpec{
+		OutputDirectory: powerArgs.OutputDirectory,
+		Manifest:        manifest,
+		DeploymentName:  powerArgs.DeploymentName,
+		ResourceGroup:   powerArgs.ResourceGroup,
+	})
+	if err != nil {
+		log.Fatalf("Failed to stop the deployment: %q", err)
+	}
+
+	log.Infof("Stop Complete!")
+}
+
+func runStart(cmd *cobra.Command, args []string) {
+	rootArgs, powerArgs, manifest := parsePowerArgs(cmd, args)
+	azureClient, err := getClient(rootArgs)
+	if err != nil {
+		log.Fatalf("Error occurred while creating the Azure client: %q", err)
+	}
+
+	if manifest == nil && !powerArgs.SkipValidation {
+		log.Fatalf("Validating the started cluster requires the deployment's output directory. Use --skip-validation to start it without one.")
+	}
+
+	err = azkube.NewDeployer(azureClient).Start(context.Background(), azkube.PowerSpec{
+		OutputDirectory: powerArgs.OutputDirectory,
+		Manifest:        manifest,
+		DeploymentName:  powerArgs.DeploymentName,
+		ResourceGroup:   powerArgs.ResourceGroup,
+		SkipValidation:  powerArgs.SkipValidation,
+	})
+	if err != nil {
+		log.Fatalf("Failed to start the deployment: %q", err)
+	}
+
+	log.Infof("Start Complete!")
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"context"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/azkube"
+	"github.com/colemickens/azkube/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	protectLongDescription   = "put a CanNotDelete management lock on a deployment's resource group"
+	unprotectLongDescription = "remove the management lock added by protect"
+)
+
+type ProtectArguments struct {
+	OutputDirectory string
+	DeploymentName  string
+	ResourceGroup   string
+}
+
+func NewProtectCmd() *cobra.Command {
+	protectCmd := &cobra.Command{
+		Use:   "protect",
+		Short: protectLongDescription,
+		Long:  protectLongDescription,
+		Run:   runProtect,
+	}
+	addProtectTargetFlags(protectCmd)
+
+	return protectCmd
+}
+
+func NewUnprotectCmd() *cobra.Command {
+	unprotectCmd := &cobra.Command{
+		Use:   "unprotect",
+		Short: unprotectLongDescription,
+		Long:  unprotectLongDescription,
+		Run:   runUnprotect,
+	}
+	addProtectTargetFlags(unprotectCmd)
+
+	return unprotectCmd
+}
+
+func addProtectTargetFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.String("output-directory", "", "output directory of the deployment (derived from --deployment-name if omitted)")
+	flags.String("deployment-name", "", "deployment name (required unless --output-directory is set)")
+	flags.String("resource-group", "", "resource group name (read from the deployment manifest, or derived from --deployment-name if unset)")
+}
+
+func parseProtectArgs(cmd *cobra.Command, args []string) (RootArguments, ProtectArguments, *util.DeploymentManifest) {
+	flags := cmd.Flags()
+	viper.BindPFlag("output-directory", flags.Lookup("output-directory"))
+	viper.BindPFlag("deployment-name", flags.Lookup("deployment-name"))
+	viper.BindPFlag("resource-group", flags.Lookup("resource-group"))
+
+	protectArgs := ProtectArguments{
+		OutputDirectory: viper.GetString("output-directory"),
+		DeploymentName:  viper.GetString("deployment-name"),
+		ResourceGroup:   viper.GetString("resource-group"),
+	}
+
+	var manifest *util.DeploymentManifest
+	protectArgs.OutputDirectory, manifest = resolveManifest(protectArgs.OutputDirectory, protectArgs.DeploymentName)
+
+	rootArgs := parseRootArgsWithManifest(cmd, args, manifest)
+
+	if manifest != nil {
+		if protectArgs.DeploymentName == "" {
+			protectArgs.DeploymentName = manifest.DeploymentName
+		}
+		if protectArgs.ResourceGroup == "" {
+			protectArgs.ResourceGroup = manifest.ResourceGroup
+		}
+	}
+
+	if protectArgs.DeploymentName == "" {
+		log.Fatalf("--deployment-name or --output-directory must be set!")
+	}
+
+	if protectArgs.ResourceGroup == "" {
+		protectArgs.ResourceGroup = protectArgs.DeploymentName
+		log.Warnf("--resource-group is unset. deriving it from --deployment-name: %q.", protectArgs.ResourceGroup)
+	}
+
+	return rootArgs, protectArgs, manifest
+}
+
+func runProtect(cmd *cobra.Command, args []string) {
+	rootArgs, protectArgs, manifest := parseProtectArgs(cmd, args)
+	azureClient, err := getClient(rootArgs)
+	if err != nil {
+		log.Fatalf("Error occurred while creating the Azure client: %q", err)
+	}
+
+	err = azkube.NewDeployer(azureClient).Protect(context.Background(), azkube.ProtectSpec{
+		OutputDirectory: protectArgs.OutputDirectory,
+		Manifest:        manifest,
+		DeploymentName:  protectArgs.DeploymentName,
+		ResourceGroup:   protectArgs.ResourceGroup,
+	})
+	if err != nil {
+		log.Fatalf("Failed to protect the deployment: %q", err)
+	}
+
+	log.Infof("Resource group %q is protected.", protectArgs.ResourceGroup)
+}
+
+func runUnprotect(cmd *cobra.Command, args []string) {
+	rootArgs, protectArgs, manifest := parseProtectArgs(cmd, args)
+	azureClient, err := getClient(rootArgs)
+	if err != nil {
+		log.Fatalf("Error occurred while creating the Azure client: %q", err)
+	}
+
+	err = azkube.NewDeployer(azureClient).Unprotect(context.Background(), azkube.ProtectSpec{
+		OutputDirectory: protectArgs.OutputDirectory,
+		Manifest:        manifest,
+		DeploymentName:  protectArgs.DeploymentName,
+		ResourceGroup:   protectArgs.ResourceGroup,
+	})
+	if err != nil {
+		log.Fatalf("Failed to unprotect the deployment: %q", err)
+	}
+
+	log.Infof("Resource group %q is no longer protected by azkube.", protectArgs.ResourceGroup)
+}
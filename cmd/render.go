@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/azkube"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	renderLongDescription = "renders the templates, keys and certificates for a deployment without touching Azure"
+
+	renderPlaceholderTenantID     = "00000000-0000-0000-0000-000000000000"
+	renderPlaceholderClientID     = "00000000-0000-0000-0000-000000000000"
+	renderPlaceholderClientSecret = "PLACEHOLDER-CLIENT-SECRET"
+)
+
+func NewRenderCmd() *cobra.Command {
+	renderCmd := &cobra.Command{
+		Use:   "render",
+		Short: renderLongDescription,
+		Long:  renderLongDescription,
+		Run:   runRender,
+	}
+
+	flags := renderCmd.Flags()
+	addDeployFlags(flags)
+	flags.String("service-principal-tenant-id", renderPlaceholderTenantID, "tenant id to render into the cluster's cloud provider configuration")
+	flags.String("service-principal-client-id", renderPlaceholderClientID, "service principal client id to render into the cluster's cloud provider configuration")
+	flags.String("service-principal-client-secret", renderPlaceholderClientSecret, "service principal client secret to render into the cluster's cloud provider configuration")
+
+	return renderCmd
+}
+
+func parseRenderArgs(cmd *cobra.Command, args []string) azkube.RenderSpec {
+	if viper.GetBool("debug") {
+		log.SetLevel(log.DebugLevel)
+		log.Debugf("debug logging enabled")
+	}
+
+	flags := cmd.Flags()
+	viper.BindPFlag("service-principal-tenant-id", flags.Lookup("service-principal-tenant-id"))
+	viper.BindPFlag("service-principal-client-id", flags.Lookup("service-principal-client-id"))
+	viper.BindPFlag("service-principal-client-secret", flags.Lookup("service-principal-client-secret"))
+
+	renderSpec := azkube.RenderSpec{
+		DeploySpec:     parseDeployFlags(cmd),
+		SubscriptionID: viper.GetString("subscription-id"),
+		TenantID:       viper.GetString("service-principal-tenant-id"),
+		Environment:    parseEnvironment(viper.GetString("azure-environment"), viper.GetString("azure-environment-file")),
+	}
+	renderSpec.ServicePrincipalClientID = viper.GetString("service-principal-client-id")
+	renderSpec.ServicePrincipalClientSecret = viper.GetString("service-principal-client-secret")
+
+	if !renderSpec.NoCloudProvider && renderSpec.ServicePrincipalClientSecret == renderPlaceholderClientSecret {
+		log.Warnf("--service-principal-client-secret is unset. Rendering a placeholder value.")
+	}
+
+	err := renderSpec.Complete(renderSpec.Environment)
+	if err != nil {
+		log.Fatalf("Invalid deployment arguments: %q", err)
+	}
+
+	return renderSpec
+}
+
+func runRender(cmd *cobra.Command, args []string) {
+	renderSpec := parseRenderArgs(cmd, args)
+
+	_, err := azkube.Render(renderSpec)
+	if err != nil {
+		log.Fatalf("Error occurred while rendering the deployment: %q", err)
+	}
+
+	log.Infof("Render Complete!")
+	log.Infof("output: %q", renderSpec.OutputDirectory)
+}
@@ -1,23 +1,34 @@
 package cmd
 
 import (
-	//"fmt"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/azkube"
 	"github.com/colemickens/azkube/util"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 const (
-	scaleLongDescription = "scale a deployment's vm scale set"
+	scaleLongDescription = "scale one of a deployment's node pools"
 )
 
 type ScaleArguments struct {
-	DeploymentName string
-	ResourceGroup  string
-	NodeCount      int
-	NodeSize       string
+	OutputDirectory   string
+	DeploymentName    string
+	ResourceGroup     string
+	Pool              string
+	NodeCount         int
+	RelativeNodeCount bool
+	NodeSize          string
+	SkipConfirm       bool
+	SkipDrain         bool
+	SkipValidation    bool
+	MaxUnavailable    int
 }
 
 func NewScaleDeploymentCmd() *cobra.Command {
@@ -29,32 +40,77 @@ func NewScaleDeploymentCmd() *cobra.Command {
 	}
 
 	flags := scaleCmd.Flags()
-	flags.String("deployment-name", "", "deployment name (required)")
-	flags.String("resource-group", "", "resource group name (derived from --deployment-name if unset)")
-	flags.Int("node-count", -1, "number of nodes to scale to (required)")
-	flags.String("node-size", "", "size of nodes to scale (required")
+	flags.String("output-directory", "", "output directory of the deployment (derived from --deployment-name if omitted)")
+	flags.String("deployment-name", "", "deployment name (required unless --output-directory is set)")
+	flags.String("resource-group", "", "resource group name (read from the deployment manifest, or derived from --deployment-name if unset)")
+	flags.String("pool", util.DefaultNodePoolName, "node pool to scale")
+	flags.String("node-count", "", "number of nodes to scale to, or a relative change such as `+2` or `-1` (required unless --node-size is set)")
+	flags.String("node-size", "", "new size for the nodes, rolled out to existing nodes in batches (the scale set's current size is kept if unset)")
+	flags.Int("max-unavailable", 1, "number of existing nodes to drain and move onto a new size at a time")
+	flags.Bool("skip-confirm", false, "skip confirmation when removing or rolling nodes")
+	flags.Bool("skip-drain", false, "remove nodes without cordoning and draining them first")
+	flags.Bool("skip-validation", false, "don't wait for the cluster to report the new number of ready nodes")
 
 	return scaleCmd
 }
 
-func parseScaleArgs(cmd *cobra.Command, args []string) (RootArguments, ScaleArguments) {
-	rootArgs := parseRootArgs(cmd, args)
-
+func parseScaleArgs(cmd *cobra.Command, args []string) (RootArguments, ScaleArguments, *util.DeploymentManifest) {
 	flags := cmd.Flags()
+	viper.BindPFlag("output-directory", flags.Lookup("output-directory"))
 	viper.BindPFlag("deployment-name", flags.Lookup("deployment-name"))
 	viper.BindPFlag("resource-group", flags.Lookup("resource-group"))
+	viper.BindPFlag("pool", flags.Lookup("pool"))
 	viper.BindPFlag("node-count", flags.Lookup("node-count"))
 	viper.BindPFlag("node-size", flags.Lookup("node-size"))
+	viper.BindPFlag("skip-confirm", flags.Lookup("skip-confirm"))
+	viper.BindPFlag("skip-drain", flags.Lookup("skip-drain"))
+	viper.BindPFlag("skip-validation", flags.Lookup("skip-validation"))
+	viper.BindPFlag("max-unavailable", flags.Lookup("max-unavailable"))
 
 	scaleArgs := ScaleArguments{
-		DeploymentName: viper.GetString("deployment-name"),
-		ResourceGroup:  viper.GetString("resource-group"),
-		NodeCount:      viper.GetInt("node-count"),
-		NodeSize:       viper.GetString("node-size"),
+		OutputDirectory: viper.GetString("output-directory"),
+		DeploymentName:  viper.GetString("deployment-name"),
+		ResourceGroup:   viper.GetString("resource-group"),
+		Pool:            viper.GetString("pool"),
+		NodeSize:        viper.GetString("node-size"),
+		SkipConfirm:     viper.GetBool("skip-confirm"),
+		SkipDrain:       viper.GetBool("skip-drain"),
+		SkipValidation:  viper.GetBool("skip-validation"),
+		MaxUnavailable:  viper.GetInt("max-unavailable"),
+	}
+
+	nodeCount := viper.GetString("node-count")
+	if nodeCount == "" {
+		if scaleArgs.NodeSize == "" {
+			log.Fatalf("--node-count or --node-size must be specified.")
+		}
+		nodeCount = "+0"
+	}
+	if scaleArgs.MaxUnavailable < 1 {
+		log.Fatalf("--max-unavailable must be at least 1.")
+	}
+	var err error
+	scaleArgs.NodeCount, scaleArgs.RelativeNodeCount, err = parseNodeCount(nodeCount)
+	if err != nil {
+		log.Fatalf("--node-count: %q", err)
+	}
+
+	var manifest *util.DeploymentManifest
+	scaleArgs.OutputDirectory, manifest = resolveManifest(scaleArgs.OutputDirectory, scaleArgs.DeploymentName)
+
+	rootArgs := parseRootArgsWithManifest(cmd, args, manifest)
+
+	if manifest != nil {
+		if scaleArgs.DeploymentName == "" {
+			scaleArgs.DeploymentName = manifest.DeploymentName
+		}
+		if scaleArgs.ResourceGroup == "" {
+			scaleArgs.ResourceGroup = manifest.ResourceGroup
+		}
 	}
 
 	if scaleArgs.DeploymentName == "" {
-		log.Errorf("--deployment-name must be set!")
+		log.Fatalf("--deployment-name or --output-directory must be set!")
 	}
 
 	if scaleArgs.ResourceGroup == "" {
@@ -62,47 +118,83 @@ func parseScaleArgs(cmd *cobra.Command, args []string) (RootArguments, ScaleArgu
 		log.Warnf("--resource-group is unset. deriving it from --deployment-name: %q.", scaleArgs.ResourceGroup)
 	}
 
-	if scaleArgs.NodeCount == -1 {
-		log.Errorf("--node-count must be specified.")
-	}
+	return rootArgs, scaleArgs, manifest
+}
 
-	if scaleArgs.NodeSize == "" {
-		log.Errorf("--node-size must be specified.")
+// parseNodeCount accepts an absolute count ("5") or a relative change ("+2", "-1").
+func parseNodeCount(value string) (count int, relative bool, err error) {
+	relative = strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-")
+	count, err = strconv.Atoi(value)
+	if err != nil {
+		return 0, false, fmt.Errorf("expected a number of nodes or a relative change such as +2 or -1: %q", value)
 	}
-
-	return rootArgs, scaleArgs
+	if !relative && count < 0 {
+		return 0, false, fmt.Errorf("node count must not be negative: %q", value)
+	}
+	return count, relative, nil
 }
 
 func runScale(cmd *cobra.Command, args []string) {
-	rootArgs, scaleArgs := parseScaleArgs(cmd, args)
+	rootArgs, scaleArgs, manifest := parseScaleArgs(cmd, args)
 	azureClient, err := getClient(rootArgs)
 	if err != nil {
 		log.Fatalf("Error occurred while creating the Azure client: %q", err)
 	}
 
-	var flavor string = "coreos"
-
-	flavorArgs := util.FlavorArguments{
-		DeploymentName: scaleArgs.DeploymentName,
-		NodeCount:      scaleArgs.NodeCount,
-		NodeSize:       scaleArgs.NodeSize,
+	deployer := azkube.NewDeployer(azureClient)
+	scaleSpec := azkube.ScaleSpec{
+		OutputDirectory:   scaleArgs.OutputDirectory,
+		Manifest:          manifest,
+		DeploymentName:    scaleArgs.DeploymentName,
+		ResourceGroup:     scaleArgs.ResourceGroup,
+		Pool:              scaleArgs.Pool,
+		NodeCount:         scaleArgs.NodeCount,
+		RelativeNodeCount: scaleArgs.RelativeNodeCount,
+		NodeSize:          scaleArgs.NodeSize,
+		SkipDrain:         scaleArgs.SkipDrain,
+		SkipValidation:    scaleArgs.SkipValidation,
+		MaxUnavailable:    scaleArgs.MaxUnavailable,
 	}
 
-	template, err := util.PopulateTemplateMap(flavor, "scale-deploy.in.json", struct{}{})
+	plan, err := deployer.PlanScale(context.Background(), scaleSpec)
 	if err != nil {
-		log.Fatalf("Failed to populate scale deployment template")
+		log.Fatalf("Failed to read the current scale set: %q", err)
 	}
-	parameters, err := util.PopulateTemplateMap(flavor, "scale-parameters.in.json", flavorArgs)
-	if err != nil {
-		log.Fatalf("Failed to populate scale deployment parameters")
+
+	log.Infof("Node pool:  %s", plan.Pool)
+	log.Infof("Node count: %d -> %d", plan.CurrentNodeCount, plan.NodeCount)
+	log.Infof("Node size:  %s -> %s", plan.CurrentNodeSize, plan.NodeSize)
+	if plan.Shrinking() {
+		log.Warnf("Going to remove %d node(s) from %q.", plan.CurrentNodeCount-plan.NodeCount, plan.ScaleSetName)
+		for _, instance := range plan.RemoveInstances {
+			log.Warnf("  instance=%s node=%s", instance.InstanceID, instance.NodeName)
+		}
+		if scaleArgs.SkipDrain {
+			log.Warnf("--skip-drain is set. Pods on these nodes will not be evicted first.")
+		}
+		if !scaleArgs.SkipConfirm {
+			confirmOrExit("removal")
+		}
+	}
+	if len(plan.RollInstances) > 0 {
+		log.Warnf("Going to move %d existing node(s) onto %s, %d at a time.", len(plan.RollInstances), plan.NodeSize, scaleArgs.MaxUnavailable)
+		for _, instance := range plan.RollInstances {
+			log.Warnf("  instance=%s node=%s", instance.InstanceID, instance.NodeName)
+		}
+		if !plan.Shrinking() && !scaleArgs.SkipConfirm {
+			confirmOrExit("rollout")
+		}
 	}
 
-	_, err = azureClient.DeployTemplate(
-		scaleArgs.ResourceGroup,
-		scaleArgs.DeploymentName+"-scale",
-		template,
-		parameters)
+	// pin the planned values so a relative change is applied exactly once
+	scaleSpec.NodeCount = plan.NodeCount
+	scaleSpec.RelativeNodeCount = false
+	scaleSpec.NodeSize = plan.NodeSize
+
+	err = deployer.Scale(context.Background(), scaleSpec)
 	if err != nil {
-		log.Fatalf("Failed to deploy the scale change: %q", err)
+		log.Fatalf("Failed to scale the deployment: %q", err)
 	}
+
+	log.Infof("Scale Complete!")
 }
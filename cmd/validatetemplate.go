@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/colemickens/azkube/azkube"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	validateTemplateLongDescription = "validate a rendered deployment's ARM template against Azure without creating anything"
+)
+
+type ValidateTemplateArguments struct {
+	OutputDirectory string
+	DeploymentName  string
+}
+
+func NewValidateTemplateCmd() *cobra.Command {
+	var validateTemplateCmd = &cobra.Command{
+		Use:   "validate-template",
+		Short: validateTemplateLongDescription,
+		Long:  validateTemplateLongDescription,
+		Run:   runValidateTemplate,
+	}
+
+	flags := validateTemplateCmd.Flags()
+	flags.String("output-directory", "", "output directory of the rendered deployment (derived from --deployment-name if omitted)")
+	flags.String("deployment-name", "", "deployment name (required unless --output-directory is set)")
+
+	return validateTemplateCmd
+}
+
+func parseValidateTemplateArgs(cmd *cobra.Command, args []string) (RootArguments, azkube.ValidateTemplateSpec) {
+	flags := cmd.Flags()
+	viper.BindPFlag("output-directory", flags.Lookup("output-directory"))
+	viper.BindPFlag("deployment-name", flags.Lookup("deployment-name"))
+
+	validateTemplateArgs := ValidateTemplateArguments{
+		OutputDirectory: viper.GetString("output-directory"),
+		DeploymentName:  viper.GetString("deployment-name"),
+	}
+
+	if validateTemplateArgs.OutputDirectory == "" && validateTemplateArgs.DeploymentName == "" {
+		log.Fatalf("--deployment-name or --output-directory must be set.")
+	}
+
+	outputDirectory, manifest := resolveManifest(validateTemplateArgs.OutputDirectory, validateTemplateArgs.DeploymentName)
+	if manifest == nil {
+		log.Fatalf("No deployment manifest found in %q. Run `azkube render` first.", outputDirectory)
+	}
+
+	rootArgs := parseRootArgsWithManifest(cmd, args, manifest)
+
+	return rootArgs, azkube.ValidateTemplateSpec{
+		OutputDirectory: outputDirectory,
+		Manifest:        manifest,
+	}
+}
+
+func runValidateTemplate(cmd *cobra.Command, args []string) {
+	rootArgs, validateTemplateSpec := parseValidateTemplateArgs(cmd, args)
+
+	azureClient, err := getClient(rootArgs)
+	if err != nil {
+		log.Fatalf("Error occurred while creating the Azure client: %q", err)
+	}
+
+	err = azkube.NewDeployer(azureClient).ValidateTemplate(context.Background(), validateTemplateSpec)
+	if err != nil {
+		log.Fatalf("Template validation failed: %q", err)
+	}
+
+	log.Infof("Template is valid. output: %q", validateTemplateSpec.OutputDirectory)
+}
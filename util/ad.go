@@ -9,6 +9,7 @@ import (
 
 	"github.com/Azure/azure-sdk-for-go/arm/authorization"
 	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
 	log "github.com/Sirupsen/logrus"
 	"github.com/pborman/uuid"
 )
@@ -24,6 +25,10 @@ const (
 	AzureAdAssignedRoleId        = AzureAdOwnerRoleId
 
 	ServicePrincipalKeySize = 4096
+
+	// roleAssignmentReplicationTimeout bounds the wait for a new service
+	// principal to become known to resource manager.
+	roleAssignmentReplicationTimeout = 5 * time.Minute
 )
 
 var (
@@ -69,7 +74,19 @@ type AdRoleAssignment struct {
 	PrincipalID      string `json:"principalId,omitempty"`
 }
 
-func (azureClient *AzureClient) CreateApp(appName, appURL string) (applicationID, servicePrincipalObjectID, servicePrincipalClientSecret string, err error) {
+type adApplicationList struct {
+	Value []AdApplication `json:"value"`
+}
+
+type adServicePrincipalList struct {
+	Value []AdServicePrincipal `json:"value"`
+}
+
+func (azureClient *AzureClient) adURL() string {
+	return fmt.Sprintf("%s/%s", azureClient.Environment.GraphEndpoint, azureClient.TenantID)
+}
+
+func newAdPasswordCredential() (AdPasswordCredential, string) {
 	notBefore := time.Now()
 	notAfter := time.Now().Add(5 * 365 * 24 * time.Hour)
 	notAfter = time.Now().Add(10000 * 24 * time.Hour)
@@ -77,7 +94,78 @@ func (azureClient *AzureClient) CreateApp(appName, appURL string) (applicationID
 	startDate := notBefore.Format(time.RFC3339)
 	endDate := notAfter.Format(time.RFC3339)
 
-	servicePrincipalClientSecret = uuid.New()
+	servicePrincipalClientSecret := uuid.New()
+
+	return AdPasswordCredential{
+		KeyId:     uuid.New(),
+		StartDate: startDate,
+		EndDate:   endDate,
+		Value:     servicePrincipalClientSecret,
+	}, servicePrincipalClientSecret
+}
+
+// AdAppCredentials identifies an application, its service principal and the
+// client secret issued for it, and records which of them were newly created.
+type AdAppCredentials struct {
+	ApplicationID            string
+	ApplicationObjectID      string
+	ServicePrincipalObjectID string
+	ClientSecret             string
+
+	CreatedApplication      bool
+	CreatedServicePrincipal bool
+}
+
+// EnsureApp returns the application and service principal registered under
+// appURL, creating them if needed. An application left behind by an earlier,
+// interrupted run is reused and issued a new client secret instead of leaking
+// it, but only if owned reports that the deployment created it. Any other
+// application with the same identifier uri is a conflict.
+func (azureClient *AzureClient) EnsureApp(appName, appURL string, owned func(application *AdApplication) bool) (*AdAppCredentials, error) {
+	application, err := azureClient.GetAppByIdentifierURI(appURL)
+	if err != nil {
+		return nil, err
+	}
+	if application == nil {
+		return azureClient.CreateApp(appName, appURL)
+	}
+	if !owned(application) {
+		return nil, fmt.Errorf("ad: an application with identifier uri %q already exists and was not created by this deployment. appId=%q", appURL, application.ApplicationID)
+	}
+
+	log.Warnf("ad: found existing application. Reusing it and resetting its credentials. appId=%q identifierURL=%q", application.ApplicationID, appURL)
+
+	passwordCredential, servicePrincipalClientSecret := newAdPasswordCredential()
+	err = azureClient.setAppPasswordCredentials(application.ObjectID, []AdPasswordCredential{passwordCredential})
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := &AdAppCredentials{
+		ApplicationID:       application.ApplicationID,
+		ApplicationObjectID: application.ObjectID,
+		ClientSecret:        servicePrincipalClientSecret,
+	}
+
+	servicePrincipal, err := azureClient.GetServicePrincipalByAppID(application.ApplicationID)
+	if err != nil {
+		return nil, err
+	}
+	if servicePrincipal == nil {
+		credentials.ServicePrincipalObjectID, err = azureClient.createServicePrincipal(application.ApplicationID)
+		if err != nil {
+			return nil, err
+		}
+		credentials.CreatedServicePrincipal = true
+	} else {
+		credentials.ServicePrincipalObjectID = servicePrincipal.ObjectID
+	}
+
+	return credentials, nil
+}
+
+func (azureClient *AzureClient) CreateApp(appName, appURL string) (*AdAppCredentials, error) {
+	passwordCredential, servicePrincipalClientSecret := newAdPasswordCredential()
 
 	log.Debugf("ad: creating application with name=%q identifierURL=%q", appName, appURL)
 
@@ -86,36 +174,27 @@ func (azureClient *AzureClient) CreateApp(appName, appURL string) (applicationID
 		DisplayName:             appName,
 		Homepage:                appURL,
 		IdentifierURIs:          []string{appURL},
-		PasswordCredentials: []AdPasswordCredential{
-			AdPasswordCredential{
-				KeyId:     uuid.New(),
-				StartDate: startDate,
-				EndDate:   endDate,
-				Value:     servicePrincipalClientSecret,
-			},
-		},
+		PasswordCredentials:     []AdPasswordCredential{passwordCredential},
 	}
 
 	q := map[string]interface{}{"api-version": AzureAdApiVersion}
 
-	azureAdURL := fmt.Sprintf("%s/%s", azureClient.Environment.GraphEndpoint, azureClient.TenantID)
-
 	req, err := autorest.Prepare(&http.Request{},
 		autorest.AsJSON(),
 		autorest.AsPost(),
-		autorest.WithBaseURL(azureAdURL),
+		autorest.WithBaseURL(azureClient.adURL()),
 		autorest.WithPath("applications"),
 		autorest.WithQueryParameters(q),
 		autorest.WithJSON(applicationReq))
 	if err != nil {
 		log.Errorf("ad: failed to prepare the application creation request")
-		return "", "", "", err
+		return nil, err
 	}
 
 	resp, err := azureClient.AdClient.Do(req)
 	if err != nil {
 		log.Errorf("ad: failed to send the application creation request")
-		return "", "", "", err
+		return nil, err
 	}
 
 	var applicationResp AdApplication
@@ -125,11 +204,27 @@ func (azureClient *AzureClient) CreateApp(appName, appURL string) (applicationID
 		autorest.ByUnmarshallingJSON(&applicationResp))
 	if err != nil {
 		log.Errorf("ad: failed to respond to application creation response")
-		return "", "", "", err
+		return nil, err
+	}
+
+	credentials := &AdAppCredentials{
+		ApplicationID:       applicationResp.ApplicationID,
+		ApplicationObjectID: applicationResp.ObjectID,
+		ClientSecret:        servicePrincipalClientSecret,
+		CreatedApplication:  true,
 	}
 
-	applicationID = applicationResp.ApplicationID
+	credentials.ServicePrincipalObjectID, err = azureClient.createServicePrincipal(credentials.ApplicationID)
+	if err != nil {
+		// the application exists now, so hand it back for cleanup
+		return credentials, err
+	}
+	credentials.CreatedServicePrincipal = true
+
+	return credentials, nil
+}
 
+func (azureClient *AzureClient) createServicePrincipal(applicationID string) (servicePrincipalObjectID string, err error) {
 	servicePrincipalReq := AdServicePrincipal{
 		ApplicationID:  applicationID,
 		AccountEnabled: true,
@@ -137,22 +232,24 @@ func (azureClient *AzureClient) CreateApp(appName, appURL string) (applicationID
 
 	log.Debugf("ad: creating servicePrincipal for applicationID: %q", applicationID)
 
-	req, err = autorest.Prepare(&http.Request{},
+	q := map[string]interface{}{"api-version": AzureAdApiVersion}
+
+	req, err := autorest.Prepare(&http.Request{},
 		autorest.AsJSON(),
 		autorest.AsPost(),
-		autorest.WithBaseURL(azureAdURL),
+		autorest.WithBaseURL(azureClient.adURL()),
 		autorest.WithPath("servicePrincipals"),
 		autorest.WithQueryParameters(q),
 		autorest.WithJSON(servicePrincipalReq))
 	if err != nil {
 		log.Errorf("ad: failed to prepare the servicePrincipal creation request")
-		return "", "", "", err
+		return "", err
 	}
 
-	resp, err = azureClient.AdClient.Do(req)
+	resp, err := azureClient.AdClient.Do(req)
 	if err != nil {
 		log.Errorf("ad: failed to send the servicePrincipal creation request")
-		return "", "", "", err
+		return "", err
 	}
 
 	var servicePrincipalResp AdServicePrincipal
@@ -162,15 +259,115 @@ func (azureClient *AzureClient) CreateApp(appName, appURL string) (applicationID
 		autorest.ByUnmarshallingJSON(&servicePrincipalResp))
 	if err != nil {
 		log.Errorf("ad: failed to respond to the servicePrincipal creation request")
-		return "", "", "", err
+		return "", err
 	}
 
-	servicePrincipalObjectID = servicePrincipalResp.ObjectID
+	return servicePrincipalResp.ObjectID, nil
+}
+
+func (azureClient *AzureClient) setAppPasswordCredentials(applicationObjectID string, passwordCredentials []AdPasswordCredential) error {
+	log.Debugf("ad: resetting password credentials for application (objectId=%q)", applicationObjectID)
+
+	q := map[string]interface{}{"api-version": AzureAdApiVersion}
 
-	return applicationID, servicePrincipalObjectID, servicePrincipalClientSecret, nil
+	req, err := autorest.Prepare(&http.Request{},
+		autorest.AsJSON(),
+		autorest.AsPatch(),
+		autorest.WithBaseURL(azureClient.adURL()),
+		autorest.WithPath(fmt.Sprintf("applications/%s", applicationObjectID)),
+		autorest.WithQueryParameters(q),
+		autorest.WithJSON(struct {
+			PasswordCredentials []AdPasswordCredential `json:"passwordCredentials"`
+		}{passwordCredentials}))
+	if err != nil {
+		return err
+	}
+
+	resp, err := azureClient.AdClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return autorest.Respond(
+		resp,
+		autorest.WithErrorUnlessStatusCode(http.StatusNoContent),
+		autorest.ByClosing())
 }
 
-func (azureClient *AzureClient) CreateRoleAssignment(resourceGroup, servicePrincipalObjectID string) error {
+func (azureClient *AzureClient) GetAppByIdentifierURI(appURL string) (*AdApplication, error) {
+	var applications adApplicationList
+	filter := fmt.Sprintf("identifierUris/any(s:s eq '%s')", appURL)
+	err := azureClient.adQuery("applications", filter, &applications)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(applications.Value) == 0 {
+		return nil, nil
+	}
+	return &applications.Value[0], nil
+}
+
+func (azureClient *AzureClient) GetAppByAppID(applicationID string) (*AdApplication, error) {
+	var applications adApplicationList
+	filter := fmt.Sprintf("appId eq '%s'", applicationID)
+	err := azureClient.adQuery("applications", filter, &applications)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(applications.Value) == 0 {
+		return nil, nil
+	}
+	return &applications.Value[0], nil
+}
+
+func (azureClient *AzureClient) GetServicePrincipalByAppID(applicationID string) (*AdServicePrincipal, error) {
+	var servicePrincipals adServicePrincipalList
+	filter := fmt.Sprintf("appId eq '%s'", applicationID)
+	err := azureClient.adQuery("servicePrincipals", filter, &servicePrincipals)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(servicePrincipals.Value) == 0 {
+		return nil, nil
+	}
+	return &servicePrincipals.Value[0], nil
+}
+
+func (azureClient *AzureClient) adQuery(collection, filter string, result interface{}) error {
+	q := map[string]interface{}{
+		"api-version": AzureAdApiVersion,
+		"$filter":     filter,
+	}
+
+	req, err := autorest.Prepare(&http.Request{},
+		autorest.AsGet(),
+		autorest.WithBaseURL(azureClient.adURL()),
+		autorest.WithPath(collection),
+		autorest.WithQueryParameters(q))
+	if err != nil {
+		log.Errorf("ad: failed to prepare the %s query", collection)
+		return err
+	}
+
+	resp, err := azureClient.AdClient.Do(req)
+	if err != nil {
+		log.Errorf("ad: failed to send the %s query", collection)
+		return err
+	}
+
+	return autorest.Respond(
+		resp,
+		autorest.WithErrorUnlessStatusCode(http.StatusOK),
+		autorest.ByUnmarshallingJSON(result))
+}
+
+// CreateRoleAssignment grants the service principal access to the resource
+// group and returns the id of the new role assignment. The id is empty if an
+// equivalent assignment already existed.
+func (azureClient *AzureClient) CreateRoleAssignment(resourceGroup, servicePrincipalObjectID string, cancel <-chan struct{}) (string, error) {
 	roleAssignmentName := uuid.New()
 
 	roleDefinitionId := strings.Replace(AzureAdRoleReferenceTemplate, "{subscription-id}", azureClient.SubscriptionID, -1)
@@ -187,19 +384,102 @@ func (azureClient *AzureClient) CreateRoleAssignment(resourceGroup, servicePrinc
 		},
 	}
 
-	for {
-		_, err := azureClient.RoleAssignmentsClient.Create(
+	// the new service principal is unknown to resource manager until the
+	// directory has replicated it, which shows as a bad request
+	deadline := time.Now().Add(roleAssignmentReplicationTimeout)
+	for attempt := 0; ; attempt++ {
+		result, err := azureClient.RoleAssignmentsClient.Create(
 			scope,
 			roleAssignmentName,
 			roleAssignmentParameters,
 		)
-		if err != nil {
-			log.Warnf("Failed to create role assignment (will retry): %q", err)
-			time.Sleep(3 * time.Second)
+		if err != nil && result.Response.Response != nil && result.StatusCode == http.StatusConflict {
+			log.Infof("ad: role assignment already exists for servicePrincipal (objectId=%q)", servicePrincipalObjectID)
+			return "", nil
+		}
+		if err != nil && result.Response.Response != nil && result.StatusCode == http.StatusBadRequest && time.Now().Before(deadline) {
+			delay := azureClient.RetryPolicy.Delay(attempt)
+			log.Warnf("Failed to create role assignment, retrying in %s: %q", delay, err)
+			select {
+			case <-cancel:
+				return "", fmt.Errorf("ad: canceled while creating role assignment: %q", err)
+			case <-time.After(delay):
+			}
 			continue
 		}
-		break
+		if err != nil {
+			return "", err
+		}
+		return to.String(result.ID), nil
+	}
+}
+
+// ListRoleAssignmentIDs returns the ids of the role assignments that give a
+// service principal access to a resource group.
+func (azureClient *AzureClient) ListRoleAssignmentIDs(resourceGroup, servicePrincipalObjectID string) ([]string, error) {
+	filter := fmt.Sprintf("principalId eq '%s'", servicePrincipalObjectID)
+	result, err := azureClient.RoleAssignmentsClient.ListForResourceGroup(resourceGroup, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for {
+		if result.Value != nil {
+			for _, roleAssignment := range *result.Value {
+				ids = append(ids, to.String(roleAssignment.ID))
+			}
+		}
+		if result.NextLink == nil || *result.NextLink == "" {
+			return ids, nil
+		}
+		result, err = azureClient.RoleAssignmentsClient.ListForResourceGroupNextResults(result)
+		if err != nil {
+			return nil, err
+		}
 	}
+}
+
+func (azureClient *AzureClient) DeleteRoleAssignment(roleAssignmentID string) error {
+	log.Debugf("ad: deleting role assignment (id=%q)", roleAssignmentID)
+	result, err := azureClient.RoleAssignmentsClient.DeleteByID(roleAssignmentID)
+	if err != nil && result.Response.Response != nil && result.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
 
-	return nil
+func (azureClient *AzureClient) DeleteApp(applicationObjectID string) error {
+	log.Debugf("ad: deleting application (objectId=%q)", applicationObjectID)
+	return azureClient.adDelete(fmt.Sprintf("applications/%s", applicationObjectID))
+}
+
+func (azureClient *AzureClient) DeleteServicePrincipal(servicePrincipalObjectID string) error {
+	log.Debugf("ad: deleting servicePrincipal (objectId=%q)", servicePrincipalObjectID)
+	return azureClient.adDelete(fmt.Sprintf("servicePrincipals/%s", servicePrincipalObjectID))
+}
+
+// adDelete deletes a directory object. Objects that are already gone are
+// not an error.
+func (azureClient *AzureClient) adDelete(objectPath string) error {
+	q := map[string]interface{}{"api-version": AzureAdApiVersion}
+
+	req, err := autorest.Prepare(&http.Request{},
+		autorest.AsDelete(),
+		autorest.WithBaseURL(azureClient.adURL()),
+		autorest.WithPath(objectPath),
+		autorest.WithQueryParameters(q))
+	if err != nil {
+		return err
+	}
+
+	resp, err := azureClient.AdClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return autorest.Respond(
+		resp,
+		autorest.WithErrorUnlessStatusCode(http.StatusNoContent, http.StatusNotFound),
+		autorest.ByClosing())
 }
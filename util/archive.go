@@ -0,0 +1,69 @@
+package util
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ArchiveDirectory writes a directory to a gzipped tarball readable only by
+// the current user, then removes the directory.
+func ArchiveDirectory(directory, archivePath string) error {
+	file, err := os.OpenFile(archivePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	base := filepath.Dir(filepath.Clean(directory))
+	err = filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name, err = filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(header.Name)
+		err = tarWriter.WriteHeader(header)
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		contents, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer contents.Close()
+		_, err = io.Copy(tarWriter, contents)
+		return err
+	})
+	if err != nil {
+		os.Remove(archivePath)
+		return err
+	}
+
+	err = tarWriter.Close()
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		os.Remove(archivePath)
+		return err
+	}
+
+	return os.RemoveAll(directory)
+}
@@ -0,0 +1,137 @@
+package util
+
+import (
+	"strings"
+
+	k8sapi "k8s.io/kubernetes/pkg/api"
+	k8s "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/fields"
+)
+
+// ResourceRequest is an amount of cpu, in millicores, and memory, in bytes.
+type ResourceRequest struct {
+	MilliCPU int64
+	Memory   int64
+}
+
+func (r ResourceRequest) Add(other ResourceRequest) ResourceRequest {
+	return ResourceRequest{MilliCPU: r.MilliCPU + other.MilliCPU, Memory: r.Memory + other.Memory}
+}
+
+// NodeUsage is how much of a node's allocatable resources are requested by
+// the pods scheduled on it.
+type NodeUsage struct {
+	Name        string
+	Requested   ResourceRequest
+	Allocatable ResourceRequest
+}
+
+// Utilization is the larger of the node's requested cpu and memory
+// fractions.
+func (usage NodeUsage) Utilization() float64 {
+	utilization := 0.0
+	if usage.Allocatable.MilliCPU > 0 {
+		utilization = float64(usage.Requested.MilliCPU) / float64(usage.Allocatable.MilliCPU)
+	}
+	if usage.Allocatable.Memory > 0 {
+		memory := float64(usage.Requested.Memory) / float64(usage.Allocatable.Memory)
+		if memory > utilization {
+			utilization = memory
+		}
+	}
+	return utilization
+}
+
+// KubeNodeObserver reads the scheduling state of one node pool from the
+// cluster's apiserver.
+type KubeNodeObserver struct {
+	Client         *k8s.Client
+	NodeNamePrefix string
+
+	// PoolLabels are the labels of the pool's nodes. Pending pods are only
+	// counted if their node selector matches them.
+	PoolLabels map[string]string
+}
+
+// PendingPods returns the requests of the pods that the scheduler could not
+// place and that would fit the pool's nodes.
+func (observer *KubeNodeObserver) PendingPods() ([]ResourceRequest, error) {
+	podList, err := observer.Client.Pods(k8sapi.NamespaceAll).List(k8sapi.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("status.phase", string(k8sapi.PodPending)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var requests []ResourceRequest
+	for _, pod := range podList.Items {
+		if pod.Spec.NodeName != "" || !podUnschedulable(pod) || !selectorMatches(pod.Spec.NodeSelector, observer.PoolLabels) {
+			continue
+		}
+		requests = append(requests, podRequest(pod))
+	}
+	return requests, nil
+}
+
+// PoolNodes returns the usage of the pool's Ready, schedulable nodes.
+func (observer *KubeNodeObserver) PoolNodes() ([]NodeUsage, error) {
+	nodeList, err := observer.Client.Nodes().List(k8sapi.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var usages []NodeUsage
+	for _, node := range nodeList.Items {
+		if !strings.HasPrefix(node.Name, observer.NodeNamePrefix) || node.Spec.Unschedulable || !nodeReady(node) {
+			continue
+		}
+
+		usage := NodeUsage{
+			Name:        node.Name,
+			Allocatable: resourceListRequest(node.Status.Allocatable),
+		}
+
+		pods, err := drainablePods(observer.Client, node.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range pods {
+			usage.Requested = usage.Requested.Add(podRequest(pod))
+		}
+
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+func podUnschedulable(pod k8sapi.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == k8sapi.PodScheduled && condition.Status == k8sapi.ConditionFalse && condition.Reason == k8sapi.PodReasonUnschedulable {
+			return true
+		}
+	}
+	return false
+}
+
+func selectorMatches(selector, labels map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func podRequest(pod k8sapi.Pod) ResourceRequest {
+	var request ResourceRequest
+	for _, container := range pod.Spec.Containers {
+		request = request.Add(resourceListRequest(container.Resources.Requests))
+	}
+	return request
+}
+
+func resourceListRequest(list k8sapi.ResourceList) ResourceRequest {
+	cpu := list[k8sapi.ResourceCPU]
+	memory := list[k8sapi.ResourceMemory]
+	return ResourceRequest{MilliCPU: cpu.MilliValue(), Memory: memory.Value()}
+}
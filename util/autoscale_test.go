@@ -0,0 +1,182 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/client/restclient"
+	k8s "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// fakeApiserver serves the v1 nodes and pods lists that KubeNodeObserver
+// reads, filtering pods by the field selectors it uses.
+type fakeApiserver struct {
+	nodes []map[string]interface{}
+	pods  []map[string]interface{}
+}
+
+func (s *fakeApiserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var list map[string]interface{}
+	switch r.URL.Path {
+	case "/api/v1/nodes":
+		list = map[string]interface{}{"kind": "NodeList", "apiVersion": "v1", "metadata": map[string]interface{}{}, "items": s.nodes}
+	case "/api/v1/pods":
+		items := []map[string]interface{}{}
+		for _, pod := range s.pods {
+			if podMatchesFieldSelector(pod, r.URL.Query().Get("fieldSelector")) {
+				items = append(items, pod)
+			}
+		}
+		list = map[string]interface{}{"kind": "PodList", "apiVersion": "v1", "metadata": map[string]interface{}{}, "items": items}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func podMatchesFieldSelector(pod map[string]interface{}, selector string) bool {
+	if selector == "" {
+		return true
+	}
+	parts := strings.SplitN(selector, "=", 2)
+	switch parts[0] {
+	case "status.phase":
+		return pod["status"].(map[string]interface{})["phase"] == parts[1]
+	case "spec.nodeName":
+		return pod["spec"].(map[string]interface{})["nodeName"] == parts[1]
+	}
+	return false
+}
+
+func testKubeNode(name string, ready, unschedulable bool) map[string]interface{} {
+	status := "False"
+	if ready {
+		status = "True"
+	}
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name},
+		"spec":     map[string]interface{}{"unschedulable": unschedulable},
+		"status": map[string]interface{}{
+			"allocatable": map[string]interface{}{"cpu": "2", "memory": "4Gi"},
+			"conditions":  []map[string]interface{}{{"type": "Ready", "status": status}},
+		},
+	}
+}
+
+type testKubePod struct {
+	name          string
+	nodeName      string
+	phase         string
+	unschedulable bool
+	nodeSelector  map[string]string
+	annotations   map[string]string
+	cpu           string
+	memory        string
+}
+
+func (pod testKubePod) object() map[string]interface{} {
+	conditions := []map[string]interface{}{}
+	if pod.unschedulable {
+		conditions = append(conditions, map[string]interface{}{"type": "PodScheduled", "status": "False", "reason": "Unschedulable"})
+	}
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"name": pod.name, "namespace": "default", "annotations": pod.annotations},
+		"spec": map[string]interface{}{
+			"nodeName":     pod.nodeName,
+			"nodeSelector": pod.nodeSelector,
+			"containers": []map[string]interface{}{{
+				"name":      "main",
+				"image":     "busybox",
+				"resources": map[string]interface{}{"requests": map[string]interface{}{"cpu": pod.cpu, "memory": pod.memory}},
+			}},
+		},
+		"status": map[string]interface{}{"phase": pod.phase, "conditions": conditions},
+	}
+}
+
+func newTestKubeNodeObserver(t *testing.T, apiserver *fakeApiserver) (*KubeNodeObserver, func()) {
+	server := httptest.NewServer(apiserver)
+	client, err := k8s.New(&restclient.Config{Host: server.URL})
+	if err != nil {
+		server.Close()
+		t.Fatalf("failed to create kubernetes client: %v", err)
+	}
+	observer := &KubeNodeObserver{
+		Client:         client,
+		NodeNamePrefix: "test-vm-node",
+		PoolLabels:     map[string]string{NodePoolLabel: "node"},
+	}
+	return observer, server.Close
+}
+
+func TestKubeNodeObserverPendingPods(t *testing.T) {
+	apiserver := &fakeApiserver{}
+	for _, pod := range []testKubePod{
+		{name: "unschedulable", phase: "Pending", unschedulable: true, cpu: "500m", memory: "1Gi"},
+		{name: "for-this-pool", phase: "Pending", unschedulable: true, nodeSelector: map[string]string{NodePoolLabel: "node"}, cpu: "250m", memory: "256Mi"},
+		{name: "for-another-pool", phase: "Pending", unschedulable: true, nodeSelector: map[string]string{NodePoolLabel: "gpu"}, cpu: "1", memory: "1Gi"},
+		{name: "not-scheduled-yet", phase: "Pending", cpu: "1", memory: "1Gi"},
+		{name: "pulling-images", phase: "Pending", nodeName: "test-vm-node-0", cpu: "1", memory: "1Gi"},
+		{name: "running", phase: "Running", nodeName: "test-vm-node-0", cpu: "1", memory: "1Gi"},
+	} {
+		apiserver.pods = append(apiserver.pods, pod.object())
+	}
+	observer, stop := newTestKubeNodeObserver(t, apiserver)
+	defer stop()
+
+	pending, err := observer.PendingPods()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ResourceRequest{
+		{MilliCPU: 500, Memory: 1 << 30},
+		{MilliCPU: 250, Memory: 256 << 20},
+	}
+	if !reflect.DeepEqual(pending, expected) {
+		t.Errorf("got %+v, want %+v", pending, expected)
+	}
+}
+
+func TestKubeNodeObserverPoolNodes(t *testing.T) {
+	apiserver := &fakeApiserver{
+		nodes: []map[string]interface{}{
+			testKubeNode("test-vm-master", true, true),
+			testKubeNode("test-vm-node-0", true, false),
+			testKubeNode("test-vm-node-1", false, false),
+			testKubeNode("test-vm-node-2", true, true),
+			testKubeNode("test-vm-gpu-0", true, false),
+		},
+	}
+	for _, pod := range []testKubePod{
+		{name: "app", phase: "Running", nodeName: "test-vm-node-0", cpu: "500m", memory: "1Gi"},
+		{name: "other-app", phase: "Pending", nodeName: "test-vm-node-0", cpu: "250m", memory: "512Mi"},
+		{name: "finished", phase: "Succeeded", nodeName: "test-vm-node-0", cpu: "1", memory: "1Gi"},
+		{name: "mirror", phase: "Running", nodeName: "test-vm-node-0", annotations: map[string]string{mirrorPodAnnotation: "x"}, cpu: "1", memory: "1Gi"},
+		{name: "daemon", phase: "Running", nodeName: "test-vm-node-0", annotations: map[string]string{createdByAnnotation: `{"reference":{"kind":"DaemonSet"}}`}, cpu: "1", memory: "1Gi"},
+		{name: "gpu-app", phase: "Running", nodeName: "test-vm-gpu-0", cpu: "1", memory: "1Gi"},
+	} {
+		apiserver.pods = append(apiserver.pods, pod.object())
+	}
+	observer, stop := newTestKubeNodeObserver(t, apiserver)
+	defer stop()
+
+	nodes, err := observer.PoolNodes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []NodeUsage{{
+		Name:        "test-vm-node-0",
+		Requested:   ResourceRequest{MilliCPU: 750, Memory: 1<<30 + 512<<20},
+		Allocatable: ResourceRequest{MilliCPU: 2000, Memory: 4 << 30},
+	}}
+	if !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("got %+v, want %+v", nodes, expected)
+	}
+}
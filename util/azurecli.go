@@ -0,0 +1,195 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	log "github.com/Sirupsen/logrus"
+	"github.com/mitchellh/go-homedir"
+)
+
+const (
+	// AzureCLIClientID is the client id the Azure CLI logs in with. Its
+	// refresh tokens can only be redeemed with it.
+	AzureCLIClientID = "04b07795-8ddb-461a-bbee-02f9e1bf7b46"
+
+	azureCLIProfileFilename = "azureProfile.json"
+	azureCLITokensFilename  = "accessTokens.json"
+
+	// azureCLIExpiresOnFormat is the local time format of expiresOn in
+	// accessTokens.json.
+	azureCLIExpiresOnFormat = "2006-01-02 15:04:05.999999"
+)
+
+type azureCLIProfile struct {
+	Subscriptions []azureCLISubscription `json:"subscriptions"`
+}
+
+type azureCLISubscription struct {
+	ID       string `json:"id"`
+	TenantID string `json:"tenantId"`
+	User     struct {
+		Name string `json:"name"`
+	} `json:"user"`
+}
+
+type azureCLIToken struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresOn    string `json:"expiresOn"`
+	Resource     string `json:"resource"`
+	TokenType    string `json:"tokenType"`
+	Authority    string `json:"_authority"`
+	ClientID     string `json:"_clientId"`
+	UserID       string `json:"userId"`
+}
+
+// NewClientWithAzureCLI authenticates with the tokens cached by `az login`.
+// The token is refreshed in memory only; the Azure CLI's cache is never
+// written to.
+func NewClientWithAzureCLI(azureEnvironment Environment, subscriptionID, tenantID string, retryPolicy RetryPolicy) (*AzureClient, error) {
+	oauthConfig, err := azureEnvironment.OAuthConfigForTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	azureClient := AzureClient{
+		Environment:    azureEnvironment,
+		OAuthConfig:    *oauthConfig,
+		TenantID:       tenantID,
+		SubscriptionID: subscriptionID,
+		ClientID:       AzureCLIClientID,
+		RetryPolicy:    retryPolicy,
+	}
+
+	configDir, err := azureCLIConfigDir()
+	if err != nil {
+		return nil, err
+	}
+
+	userName, err := azureCLISubscriptionUser(configDir, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := azureCLITokenForTenant(configDir, tenantID, userName, azureEnvironment.ServiceManagementEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	armSpt, err := azure.NewServicePrincipalTokenFromManualToken(azureClient.OAuthConfig, azureClient.ClientID, azureClient.Environment.ServiceManagementEndpoint, token)
+	if err != nil {
+		return nil, err
+	}
+	err = armSpt.Refresh()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to refresh the Azure CLI token, run `az login` again: %q", err)
+	}
+
+	rawToken := armSpt.Token
+	rawToken.Resource = azureClient.Environment.GraphEndpoint
+	adSpt, err := azure.NewServicePrincipalTokenFromManualToken(azureClient.OAuthConfig, azureClient.ClientID, azureClient.Environment.GraphEndpoint, rawToken)
+	if err != nil {
+		return nil, err
+	}
+	adSpt.Refresh()
+
+	return azureClient.build(armSpt, adSpt)
+}
+
+// azureCLIConfigDir returns the Azure CLI's configuration directory, which
+// AZURE_CONFIG_DIR overrides like it does for the CLI itself.
+func azureCLIConfigDir() (string, error) {
+	if configDir := os.Getenv("AZURE_CONFIG_DIR"); configDir != "" {
+		return configDir, nil
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get user home directory to look for the Azure CLI login: %q", err)
+	}
+	return filepath.Join(home, ".azure"), nil
+}
+
+// azureCLISubscriptionUser returns the user the Azure CLI accesses the
+// subscription as.
+func azureCLISubscriptionUser(configDir, subscriptionID string) (string, error) {
+	var profile azureCLIProfile
+	err := readAzureCLIFile(filepath.Join(configDir, azureCLIProfileFilename), &profile)
+	if err != nil {
+		return "", err
+	}
+
+	for _, subscription := range profile.Subscriptions {
+		if strings.EqualFold(subscription.ID, subscriptionID) {
+			return subscription.User.Name, nil
+		}
+	}
+	return "", fmt.Errorf("The Azure CLI is not logged in to subscription %q, run `az login`", subscriptionID)
+}
+
+// azureCLITokenForTenant picks the user's cached token issued by the tenant,
+// preferring one for the resource.
+func azureCLITokenForTenant(configDir, tenantID, userName, resource string) (azure.Token, error) {
+	var cliTokens []azureCLIToken
+	err := readAzureCLIFile(filepath.Join(configDir, azureCLITokensFilename), &cliTokens)
+	if err != nil {
+		return azure.Token{}, err
+	}
+
+	var found *azureCLIToken
+	for i, cliToken := range cliTokens {
+		if cliToken.RefreshToken == "" || cliToken.ClientID != AzureCLIClientID {
+			continue
+		}
+		if !strings.EqualFold(cliToken.UserID, userName) || !strings.HasSuffix(strings.ToLower(cliToken.Authority), "/"+strings.ToLower(tenantID)) {
+			continue
+		}
+		if found == nil || cliToken.Resource == resource {
+			found = &cliTokens[i]
+		}
+	}
+	if found == nil {
+		return azure.Token{}, fmt.Errorf("No Azure CLI token found for user %q in tenant %q, run `az login`", userName, tenantID)
+	}
+	log.Debugf("Using Azure CLI token. user=%q tenant=%q resource=%q", userName, tenantID, found.Resource)
+
+	token := azure.Token{
+		AccessToken:  found.AccessToken,
+		RefreshToken: found.RefreshToken,
+		Resource:     found.Resource,
+		Type:         found.TokenType,
+		ExpiresOn:    "0",
+	}
+	if expiresOn, err := time.ParseInLocation(azureCLIExpiresOnFormat, found.ExpiresOn, time.Local); err == nil {
+		token.ExpiresOn = strconv.FormatInt(expiresOn.Unix(), 10)
+	}
+	return token, nil
+}
+
+// readAzureCLIFile decodes one of the Azure CLI's json files, which may start
+// with a byte order mark.
+func readAzureCLIFile(path string, v interface{}) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("The Azure CLI is not logged in (%q not found), run `az login`", path)
+		}
+		return err
+	}
+
+	contents = bytes.TrimPrefix(contents, []byte("\xef\xbb\xbf"))
+	err = json.Unmarshal(contents, v)
+	if err != nil {
+		return fmt.Errorf("Failed to parse %q: %q", path, err)
+	}
+	return nil
+}
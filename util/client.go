@@ -9,17 +9,17 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/arm/authorization"
+	"github.com/Azure/azure-sdk-for-go/arm/compute"
+	"github.com/Azure/azure-sdk-for-go/arm/resources/locks"
 	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
 	"github.com/Azure/azure-sdk-for-go/arm/resources/subscriptions"
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/Azure/go-autorest/autorest/to"
 	log "github.com/Sirupsen/logrus"
-	"github.com/mitchellh/go-homedir"
 )
 
 const (
@@ -31,22 +31,32 @@ var (
 )
 
 type AzureClient struct {
-	Environment    azure.Environment
+	Environment    Environment
 	OAuthConfig    azure.OAuthConfig
 	SubscriptionID string
 	TenantID       string
 	ClientID       string
 
-	DeploymentsClient     resources.DeploymentsClient
-	GroupsClient          resources.GroupsClient
-	RoleAssignmentsClient authorization.RoleAssignmentsClient
-	ResourcesClient       resources.Client
-	ProvidersClient       resources.ProvidersClient
-	SubscriptionsClient   subscriptions.Client
-	AdClient              AdClient
+	// RetryPolicy is applied to every request the service clients send.
+	RetryPolicy RetryPolicy
+
+	DeploymentsClient               resources.DeploymentsClient
+	DeploymentOperationsClient      resources.DeploymentOperationsClient
+	GroupsClient                    resources.GroupsClient
+	RoleAssignmentsClient           authorization.RoleAssignmentsClient
+	ResourcesClient                 resources.Client
+	ProvidersClient                 resources.ProvidersClient
+	SubscriptionsClient             subscriptions.Client
+	VirtualMachineScaleSetsClient   compute.VirtualMachineScaleSetsClient
+	VirtualMachineScaleSetVMsClient compute.VirtualMachineScaleSetVMsClient
+	VirtualMachinesClient           compute.VirtualMachinesClient
+	ManagementLocksClient           locks.ManagementLocksClient
+	AdClient                        AdClient
+
+	armAuthorizer autorest.Authorizer
 }
 
-func NewClientWithDeviceAuth(azureEnvironment azure.Environment, subscriptionID, tenantID string) (*AzureClient, error) {
+func NewClientWithDeviceAuth(azureEnvironment Environment, subscriptionID, tenantID string, retryPolicy RetryPolicy) (*AzureClient, error) {
 	oauthConfig, err := azureEnvironment.OAuthConfigForTenant(tenantID)
 	if err != nil {
 		return nil, err
@@ -58,13 +68,13 @@ func NewClientWithDeviceAuth(azureEnvironment azure.Environment, subscriptionID,
 		TenantID:       tenantID,
 		SubscriptionID: subscriptionID,
 		ClientID:       AzkubeClientID,
+		RetryPolicy:    retryPolicy,
 	}
 
-	home, err := homedir.Dir()
+	cachePath, err := DeviceTokenCachePath(tenantID)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to get user home directory to look for cached token: %q", err)
+		return nil, err
 	}
-	cachePath := filepath.Join(home, ".azkube", fmt.Sprintf("token-cache-%s.json", tenantID))
 
 	armSpt, err := azureClient.tryLoadToken(cachePath)
 	if err != nil {
@@ -79,6 +89,7 @@ func NewClientWithDeviceAuth(azureEnvironment azure.Environment, subscriptionID,
 			if err != nil {
 				return nil, err
 			}
+			adSpt.Refresh()
 			return azureClient.build(armSpt, adSpt)
 		}
 	}
@@ -107,11 +118,12 @@ func NewClientWithDeviceAuth(azureEnvironment azure.Environment, subscriptionID,
 	if err != nil {
 		return nil, err
 	}
+	adSpt.Refresh()
 
 	return azureClient.build(armSpt, adSpt)
 }
 
-func NewClientWithClientSecret(azureEnvironment azure.Environment, subscriptionID, tenantID, clientID, clientSecret string) (*AzureClient, error) {
+func NewClientWithClientSecret(azureEnvironment Environment, subscriptionID, tenantID, clientID, clientSecret string, retryPolicy RetryPolicy) (*AzureClient, error) {
 	oauthConfig, err := azureEnvironment.OAuthConfigForTenant(tenantID)
 	if err != nil {
 		return nil, err
@@ -123,21 +135,29 @@ func NewClientWithClientSecret(azureEnvironment azure.Environment, subscriptionI
 		TenantID:       tenantID,
 		SubscriptionID: subscriptionID,
 		ClientID:       clientID,
+		RetryPolicy:    retryPolicy,
+	}
+
+	armCachePath, adCachePath, err := servicePrincipalTokenCachePaths(tenantID, clientID, azureEnvironment.ResourceManagerEndpoint)
+	if err != nil {
+		return nil, err
 	}
 
-	armSpt, err := azure.NewServicePrincipalToken(*oauthConfig, clientID, clientSecret, azureEnvironment.ServiceManagementEndpoint)
+	armSpt, err := azure.NewServicePrincipalToken(*oauthConfig, clientID, clientSecret, azureEnvironment.ServiceManagementEndpoint, tokenCallback(armCachePath))
 	if err != nil {
 		return nil, err
 	}
-	adSpt, err := azure.NewServicePrincipalToken(*oauthConfig, clientID, clientSecret, azureEnvironment.GraphEndpoint)
+	adSpt, err := azure.NewServicePrincipalToken(*oauthConfig, clientID, clientSecret, azureEnvironment.GraphEndpoint, tokenCallback(adCachePath))
 	if err != nil {
 		return nil, err
 	}
+	useCachedToken(armSpt, armCachePath)
+	useCachedToken(adSpt, adCachePath)
 
 	return azureClient.build(armSpt, adSpt)
 }
 
-func NewClientWithClientCertificate(azureEnvironment azure.Environment, subscriptionID, tenantID, clientID, certificatePath, privateKeyPath string) (*AzureClient, error) {
+func NewClientWithClientCertificate(azureEnvironment Environment, subscriptionID, tenantID, clientID, certificatePath, privateKeyPath string, retryPolicy RetryPolicy) (*AzureClient, error) {
 	oauthConfig, err := azureEnvironment.OAuthConfigForTenant(tenantID)
 	if err != nil {
 		return nil, err
@@ -149,6 +169,7 @@ func NewClientWithClientCertificate(azureEnvironment azure.Environment, subscrip
 		TenantID:       tenantID,
 		SubscriptionID: subscriptionID,
 		ClientID:       clientID,
+		RetryPolicy:    retryPolicy,
 	}
 
 	certificateData, err := ioutil.ReadFile(certificatePath)
@@ -171,18 +192,37 @@ func NewClientWithClientCertificate(azureEnvironment azure.Environment, subscrip
 		return nil, fmt.Errorf("Failed to parse rsa private key: %q", err)
 	}
 
-	armSpt, err := azure.NewServicePrincipalTokenFromCertificate(*oauthConfig, clientID, certificate, privateKey, azureEnvironment.ServiceManagementEndpoint)
+	armCachePath, adCachePath, err := servicePrincipalTokenCachePaths(tenantID, clientID, azureEnvironment.ResourceManagerEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	armSpt, err := azure.NewServicePrincipalTokenFromCertificate(*oauthConfig, clientID, certificate, privateKey, azureEnvironment.ServiceManagementEndpoint, tokenCallback(armCachePath))
 	if err != nil {
 		return nil, err
 	}
-	adSpt, err := azure.NewServicePrincipalTokenFromCertificate(*oauthConfig, clientID, certificate, privateKey, azureEnvironment.GraphEndpoint)
+	adSpt, err := azure.NewServicePrincipalTokenFromCertificate(*oauthConfig, clientID, certificate, privateKey, azureEnvironment.GraphEndpoint, tokenCallback(adCachePath))
 	if err != nil {
 		return nil, err
 	}
+	useCachedToken(armSpt, armCachePath)
+	useCachedToken(adSpt, adCachePath)
 
 	return azureClient.build(armSpt, adSpt)
 }
 
+func servicePrincipalTokenCachePaths(tenantID, clientID, resourceManagerEndpoint string) (string, string, error) {
+	armCachePath, err := servicePrincipalTokenCachePath(tenantID, clientID, resourceManagerEndpoint, false)
+	if err != nil {
+		return "", "", err
+	}
+	adCachePath, err := servicePrincipalTokenCachePath(tenantID, clientID, resourceManagerEndpoint, true)
+	if err != nil {
+		return "", "", err
+	}
+	return armCachePath, adCachePath, nil
+}
+
 func tokenCallback(path string) func(t azure.Token) error {
 	return func(token azure.Token) error {
 		err := azure.SaveToken(path, 0600, token)
@@ -216,21 +256,56 @@ func (azureClient *AzureClient) tryLoadToken(cachePath string) (*azure.ServicePr
 	return armSpt, nil
 }
 
-func (azureClient *AzureClient) build(armSpt, adSpt *azure.ServicePrincipalToken) (*AzureClient, error) {
-	adSpt.Refresh()
-	azureClient.DeploymentsClient = resources.NewDeploymentsClient(azureClient.SubscriptionID)
-	azureClient.GroupsClient = resources.NewGroupsClient(azureClient.SubscriptionID)
-	azureClient.RoleAssignmentsClient = authorization.NewRoleAssignmentsClient(azureClient.SubscriptionID)
-	azureClient.ResourcesClient = resources.NewClient(azureClient.SubscriptionID)
-	azureClient.ProvidersClient = resources.NewProvidersClient(azureClient.SubscriptionID)
+// build creates the service clients. armAuthorizer authorizes requests to
+// resource manager, adAuthorizer requests to the graph api.
+func (azureClient *AzureClient) build(armAuthorizer, adAuthorizer autorest.Authorizer) (*AzureClient, error) {
+	sender := azureClient.RetryPolicy.Sender()
+	for _, authorizer := range []autorest.Authorizer{armAuthorizer, adAuthorizer} {
+		if spt, ok := authorizer.(*azure.ServicePrincipalToken); ok {
+			spt.SetSender(sender)
+		}
+	}
+	if adSpt, ok := adAuthorizer.(*azure.ServicePrincipalToken); ok {
+		adSpt.EnsureFresh()
+	}
+
+	azureClient.armAuthorizer = armAuthorizer
+	baseURI := azureClient.Environment.ResourceManagerEndpoint
+	azureClient.DeploymentsClient = resources.NewDeploymentsClientWithBaseURI(baseURI, azureClient.SubscriptionID)
+	azureClient.DeploymentOperationsClient = resources.NewDeploymentOperationsClientWithBaseURI(baseURI, azureClient.SubscriptionID)
+	azureClient.GroupsClient = resources.NewGroupsClientWithBaseURI(baseURI, azureClient.SubscriptionID)
+	azureClient.RoleAssignmentsClient = authorization.NewRoleAssignmentsClientWithBaseURI(baseURI, azureClient.SubscriptionID)
+	azureClient.ResourcesClient = resources.NewClientWithBaseURI(baseURI, azureClient.SubscriptionID)
+	azureClient.ProvidersClient = resources.NewProvidersClientWithBaseURI(baseURI, azureClient.SubscriptionID)
+	azureClient.VirtualMachineScaleSetsClient = compute.NewVirtualMachineScaleSetsClientWithBaseURI(baseURI, azureClient.SubscriptionID)
+	azureClient.VirtualMachineScaleSetVMsClient = compute.NewVirtualMachineScaleSetVMsClientWithBaseURI(baseURI, azureClient.SubscriptionID)
+	azureClient.VirtualMachinesClient = compute.NewVirtualMachinesClientWithBaseURI(baseURI, azureClient.SubscriptionID)
+	azureClient.ManagementLocksClient = locks.NewManagementLocksClientWithBaseURI(baseURI, azureClient.SubscriptionID)
 	azureClient.AdClient = AdClient{Client: autorest.Client{}, TenantID: azureClient.TenantID}
 
-	azureClient.DeploymentsClient.Authorizer = armSpt
-	azureClient.GroupsClient.Authorizer = armSpt
-	azureClient.RoleAssignmentsClient.Authorizer = armSpt
-	azureClient.ResourcesClient.Authorizer = armSpt
-	azureClient.ProvidersClient.Authorizer = armSpt
-	azureClient.AdClient.Authorizer = adSpt
+	azureClient.DeploymentsClient.Authorizer = armAuthorizer
+	azureClient.DeploymentOperationsClient.Authorizer = armAuthorizer
+	azureClient.GroupsClient.Authorizer = armAuthorizer
+	azureClient.RoleAssignmentsClient.Authorizer = armAuthorizer
+	azureClient.ResourcesClient.Authorizer = armAuthorizer
+	azureClient.ProvidersClient.Authorizer = armAuthorizer
+	azureClient.VirtualMachineScaleSetsClient.Authorizer = armAuthorizer
+	azureClient.VirtualMachineScaleSetVMsClient.Authorizer = armAuthorizer
+	azureClient.VirtualMachinesClient.Authorizer = armAuthorizer
+	azureClient.ManagementLocksClient.Authorizer = armAuthorizer
+	azureClient.AdClient.Authorizer = adAuthorizer
+
+	azureClient.DeploymentsClient.Sender = sender
+	azureClient.DeploymentOperationsClient.Sender = sender
+	azureClient.GroupsClient.Sender = sender
+	azureClient.RoleAssignmentsClient.Sender = sender
+	azureClient.ResourcesClient.Sender = sender
+	azureClient.ProvidersClient.Sender = sender
+	azureClient.VirtualMachineScaleSetsClient.Sender = sender
+	azureClient.VirtualMachineScaleSetVMsClient.Sender = sender
+	azureClient.VirtualMachinesClient.Sender = sender
+	azureClient.ManagementLocksClient.Sender = sender
+	azureClient.AdClient.Sender = sender
 
 	err := azureClient.ensureProvidersRegistered(azureClient.SubscriptionID)
 	if err != nil {
@@ -0,0 +1,183 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/arm/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+	log "github.com/Sirupsen/logrus"
+)
+
+// ScaleSetSku returns the current vm size and capacity of a scale set.
+func (azureClient *AzureClient) ScaleSetSku(resourceGroupName, scaleSetName string) (size string, capacity int, err error) {
+	scaleSet, err := azureClient.VirtualMachineScaleSetsClient.Get(resourceGroupName, scaleSetName)
+	if err != nil {
+		return "", 0, err
+	}
+	if scaleSet.Sku == nil {
+		return "", 0, fmt.Errorf("compute: scale set has no sku. scaleSet=%q", scaleSetName)
+	}
+
+	return to.String(scaleSet.Sku.Name), int(to.Int64(scaleSet.Sku.Capacity)), nil
+}
+
+// ScaleSetTags returns the tags set on a scale set.
+func (azureClient *AzureClient) ScaleSetTags(resourceGroupName, scaleSetName string) (map[string]string, error) {
+	scaleSet, err := azureClient.VirtualMachineScaleSetsClient.Get(resourceGroupName, scaleSetName)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := map[string]string{}
+	if scaleSet.Tags != nil {
+		for key, value := range *scaleSet.Tags {
+			tags[key] = to.String(value)
+		}
+	}
+	return tags, nil
+}
+
+// ScaleSetInstance is a vm in a scale set, along with the name it registers
+// with Kubernetes.
+type ScaleSetInstance struct {
+	InstanceID string
+	NodeName   string
+
+	// LatestModelApplied is false for instances that still run an older
+	// model of the scale set, such as a previous vm size.
+	LatestModelApplied bool
+}
+
+func (azureClient *AzureClient) ListScaleSetInstances(resourceGroupName, scaleSetName string) ([]ScaleSetInstance, error) {
+	var instances []ScaleSetInstance
+
+	result, err := azureClient.VirtualMachineScaleSetVMsClient.List(resourceGroupName, scaleSetName, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if result.Value != nil {
+			for _, vm := range *result.Value {
+				instance := ScaleSetInstance{InstanceID: to.String(vm.InstanceID)}
+				if vm.Properties != nil {
+					if vm.Properties.OsProfile != nil {
+						instance.NodeName = strings.ToLower(to.String(vm.Properties.OsProfile.ComputerName))
+					}
+					instance.LatestModelApplied = to.Bool(vm.Properties.LatestModelApplied)
+				}
+				instances = append(instances, instance)
+			}
+		}
+		if result.NextLink == nil || *result.NextLink == "" {
+			break
+		}
+
+		result, err = azureClient.VirtualMachineScaleSetVMsClient.ListNextResults(result)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return instances, nil
+}
+
+// DeleteScaleSetInstances deletes specific vms from a scale set, reducing its
+// capacity accordingly.
+func (azureClient *AzureClient) DeleteScaleSetInstances(resourceGroupName, scaleSetName string, instanceIDs []string, cancel <-chan struct{}) error {
+	log.Infof("Deleting scale set instances. scaleSet=%q instances=%v", scaleSetName, instanceIDs)
+	_, err := azureClient.VirtualMachineScaleSetsClient.DeleteInstances(
+		resourceGroupName,
+		scaleSetName,
+		compute.VirtualMachineScaleSetVMInstanceRequiredIDs{InstanceIds: &instanceIDs},
+		cancel)
+	return err
+}
+
+// UpdateScaleSetInstances upgrades specific vms of a scale set to its latest
+// model, which restarts them on the scale set's current vm size.
+func (azureClient *AzureClient) UpdateScaleSetInstances(resourceGroupName, scaleSetName string, instanceIDs []string, cancel <-chan struct{}) error {
+	log.Infof("Upgrading scale set instances to the latest model. scaleSet=%q instances=%v", scaleSetName, instanceIDs)
+	_, err := azureClient.VirtualMachineScaleSetsClient.UpdateInstances(
+		resourceGroupName,
+		scaleSetName,
+		compute.VirtualMachineScaleSetVMInstanceRequiredIDs{InstanceIds: &instanceIDs},
+		cancel)
+	return err
+}
+
+// ListNodePools returns the node pools of a deployment as they exist in
+// Azure, read from its scale sets. Only the name, size and count are known.
+func (azureClient *AzureClient) ListNodePools(resourceGroupName, deploymentName string) ([]NodePool, error) {
+	var pools []NodePool
+
+	prefix := deploymentName + "-vm-"
+
+	result, err := azureClient.VirtualMachineScaleSetsClient.List(resourceGroupName)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if result.Value != nil {
+			for _, scaleSet := range *result.Value {
+				name := to.String(scaleSet.Name)
+				if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, "-scaleset") || scaleSet.Sku == nil {
+					continue
+				}
+				pools = append(pools, NodePool{
+					Name:  strings.TrimSuffix(strings.TrimPrefix(name, prefix), "-scaleset"),
+					Size:  to.String(scaleSet.Sku.Name),
+					Count: int(to.Int64(scaleSet.Sku.Capacity)),
+				})
+			}
+		}
+		if result.NextLink == nil || *result.NextLink == "" {
+			break
+		}
+
+		result, err = azureClient.VirtualMachineScaleSetsClient.ListNextResults(result)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pools, nil
+}
+
+// MasterVMName returns the name the cluster template gives to the master vm.
+func MasterVMName(deploymentName string) string {
+	return fmt.Sprintf("%s-vm-master", deploymentName)
+}
+
+// DeallocateVM stops a vm and releases its compute, keeping its disks.
+func (azureClient *AzureClient) DeallocateVM(resourceGroupName, vmName string, cancel <-chan struct{}) error {
+	log.Infof("Deallocating vm. vm=%q", vmName)
+	_, err := azureClient.VirtualMachinesClient.Deallocate(resourceGroupName, vmName, cancel)
+	return err
+}
+
+func (azureClient *AzureClient) StartVM(resourceGroupName, vmName string, cancel <-chan struct{}) error {
+	log.Infof("Starting vm. vm=%q", vmName)
+	_, err := azureClient.VirtualMachinesClient.Start(resourceGroupName, vmName, cancel)
+	return err
+}
+
+// DeallocateScaleSet stops and deallocates every instance of a scale set
+// without changing its capacity.
+func (azureClient *AzureClient) DeallocateScaleSet(resourceGroupName, scaleSetName string, cancel <-chan struct{}) error {
+	log.Infof("Deallocating scale set. scaleSet=%q", scaleSetName)
+	_, err := azureClient.VirtualMachineScaleSetsClient.Deallocate(resourceGroupName, scaleSetName, nil, cancel)
+	return err
+}
+
+func (azureClient *AzureClient) StartScaleSet(resourceGroupName, scaleSetName string, cancel <-chan struct{}) error {
+	log.Infof("Starting scale set. scaleSet=%q", scaleSetName)
+	_, err := azureClient.VirtualMachineScaleSetsClient.Start(resourceGroupName, scaleSetName, nil, cancel)
+	return err
+}
+
+func (azureClient *AzureClient) DeleteScaleSet(resourceGroupName, scaleSetName string, cancel <-chan struct{}) error {
+	log.Infof("Deleting scale set. scaleSet=%q", scaleSetName)
+	_, err := azureClient.VirtualMachineScaleSetsClient.Delete(resourceGroupName, scaleSetName, cancel)
+	return err
+}
@@ -2,13 +2,15 @@ package util
 
 import (
 	"fmt"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
 	log "github.com/Sirupsen/logrus"
 )
 
-func (azureClient *AzureClient) DeployTemplate(resourceGroupName, deploymentName string, template map[string]interface{}, parameters map[string]interface{}) (response *resources.DeploymentExtended, err error) {
+func (azureClient *AzureClient) DeployTemplate(resourceGroupName, deploymentName string, template map[string]interface{}, parameters map[string]interface{}, cancel <-chan struct{}) (response *resources.DeploymentExtended, err error) {
 	// this is needed because either ARM or the SDK can't distinguish between past
 	// deployments and current deployments with the same deploymentName.
 	uniqueSuffix := fmt.Sprintf("-%d", time.Now().Unix())
@@ -23,68 +25,119 @@ func (azureClient *AzureClient) DeployTemplate(resourceGroupName, deploymentName
 	}
 
 	log.Infof("Starting ARM Deployment. This will take some time. deployment=%q", deploymentName)
+	progress := newDeploymentProgress()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go azureClient.watchDeployment(resourceGroupName, deploymentName, progress, done, stopped)
+
 	_, err = azureClient.DeploymentsClient.CreateOrUpdate(
 		resourceGroupName,
 		deploymentName,
 		deployment,
-		nil)
+		cancel)
+	close(done)
+	<-stopped
+
+	// pick up the final states the watcher may have missed between polls
+	operations, listErr := azureClient.listDeploymentOperations(resourceGroupName, deploymentName)
+	if listErr != nil {
+		log.Warnf("Failed to fetch deployment operations: %q", listErr)
+	}
+	progress.updateAll(operations)
+	progress.logSummary()
+
 	if err != nil {
-		return nil, err
+		deploymentErr := newDeploymentError(resourceGroupName, deploymentName, err, operations)
+		for _, line := range strings.Split(deploymentErr.Report(), "\n") {
+			log.Error(line)
+		}
+		return nil, deploymentErr
 	}
 	log.Infof("Finished ARM Deployment. deployment=%q", deploymentName)
 
 	return nil, nil
 }
 
-func (azureClient *AzureClient) DeployFlavor(flavor string, flavorArgs FlavorArguments, outputDirectory string) error {
-	masterScript, err := InterpolateArmPlaceholders(flavor, "master-cloudconfig.in.yml")
+func (azureClient *AzureClient) DeployFlavor(flavor string, flavorArgs FlavorArguments, outputDirectory string, cancel <-chan struct{}) error {
+	template, parameters, err := RenderFlavor(flavor, flavorArgs, outputDirectory)
 	if err != nil {
 		return err
 	}
 
-	nodeScript, err := InterpolateArmPlaceholders(flavor, "node-cloudconfig.in.yml")
-	if err != nil {
-		return err
+	_, err = azureClient.DeployTemplate(
+		flavorArgs.ResourceGroup,
+		flavorArgs.DeploymentName,
+		template,
+		parameters,
+		cancel)
+	if deploymentErr, ok := err.(*DeploymentError); ok {
+		saveErr := SaveDeploymentError(outputDirectory, deploymentErr)
+		if saveErr != nil {
+			log.Warnf("Failed to save deployment error report: %q", saveErr)
+		} else {
+			log.Errorf("Saved deployment error report. path=%q", path.Join(outputDirectory, DeploymentErrorFilename))
+		}
 	}
-
-	template, err := PopulateTemplateMap(flavor, "cluster-deploy.in.json",
-		struct{ MasterScript, NodeScript string }{masterScript, nodeScript})
 	if err != nil {
 		return err
 	}
 
-	parameters, err := PopulateTemplateMap(flavor, "cluster-parameters.in.json", flavorArgs)
+	return nil
+}
+
+// RenderFlavor produces the ARM template, its parameters and the util script
+// for a flavor and saves them to the output directory. It does not talk to Azure.
+func RenderFlavor(flavor string, flavorArgs FlavorArguments, outputDirectory string) (template, parameters map[string]interface{}, err error) {
+	template, parameters, err = PopulateFlavor(flavor, flavorArgs)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	utilScript, err := PopulateTemplate(flavor, "util.in.sh", flavorArgs)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	err = SaveDeploymentMap(outputDirectory, "cluster-deploy.json", template, 0600)
+	err = SaveDeploymentMap(outputDirectory, RenderedTemplateFilename, template, 0600)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	err = SaveDeploymentMap(outputDirectory, "cluster-parameters.json", parameters, 0600)
+	err = SaveDeploymentMap(outputDirectory, RenderedParametersFilename, parameters, 0600)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	err = SaveDeploymentFile(outputDirectory, "util.sh", utilScript, 0700)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	_, err = azureClient.DeployTemplate(
-		flavorArgs.ResourceGroup,
-		flavorArgs.DeploymentName,
-		template,
-		parameters)
+	return template, parameters, nil
+}
+
+// PopulateFlavor produces the ARM template and its parameters for a flavor
+// without saving them.
+func PopulateFlavor(flavor string, flavorArgs FlavorArguments) (template, parameters map[string]interface{}, err error) {
+	masterScript, err := InterpolateArmPlaceholders(flavor, "master-cloudconfig.in.yml")
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	return nil
+	nodeScript, err := InterpolateArmPlaceholders(flavor, "node-cloudconfig.in.yml")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template, err = PopulateTemplateMap(flavor, "cluster-deploy.in.json",
+		struct{ MasterScript, NodeScript string }{masterScript, nodeScript})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parameters, err = PopulateTemplateMap(flavor, "cluster-parameters.in.json", flavorArgs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return template, parameters, nil
 }
@@ -0,0 +1,156 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const (
+	DeploymentErrorFilename = "deployment-error.json"
+)
+
+// ArmErrorDetail is one entry of the (possibly nested) error returned by a
+// resource provider in an operation's statusMessage.
+type ArmErrorDetail struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	Target  string `json:"target,omitempty"`
+}
+
+// DeploymentFailure describes a single failed operation of an ARM deployment.
+type DeploymentFailure struct {
+	ResourceType  string                 `json:"resourceType"`
+	ResourceName  string                 `json:"resourceName"`
+	StatusCode    string                 `json:"statusCode"`
+	Timestamp     time.Time              `json:"timestamp,omitempty"`
+	Details       []ArmErrorDetail       `json:"details,omitempty"`
+	StatusMessage map[string]interface{} `json:"statusMessage,omitempty"`
+}
+
+// DeploymentError is returned by DeployTemplate when an ARM deployment fails.
+// It carries the failed operations of the deployment in addition to the
+// error returned by ARM itself.
+type DeploymentError struct {
+	ResourceGroup  string              `json:"resourceGroup"`
+	DeploymentName string              `json:"deploymentName"`
+	Message        string              `json:"error"`
+	Failures       []DeploymentFailure `json:"failures"`
+
+	Err error `json:"-"`
+}
+
+func (deploymentErr *DeploymentError) Error() string {
+	if len(deploymentErr.Failures) == 0 {
+		return deploymentErr.Message
+	}
+
+	failures := []string{}
+	for _, failure := range deploymentErr.Failures {
+		summary := fmt.Sprintf("%s/%s (%s)", failure.ResourceType, failure.ResourceName, failure.StatusCode)
+		if len(failure.Details) > 0 {
+			detail := failure.Details[len(failure.Details)-1]
+			summary = fmt.Sprintf("%s: %s: %s", summary, detail.Code, detail.Message)
+		}
+		failures = append(failures, summary)
+	}
+	return fmt.Sprintf("deployment %q failed: %s", deploymentErr.DeploymentName, strings.Join(failures, "; "))
+}
+
+// Report renders the failures as readable, multi-line text.
+func (deploymentErr *DeploymentError) Report() string {
+	lines := []string{
+		fmt.Sprintf("ARM deployment failed. resourceGroup=%q deployment=%q", deploymentErr.ResourceGroup, deploymentErr.DeploymentName),
+		fmt.Sprintf("  error: %s", deploymentErr.Message),
+	}
+	if len(deploymentErr.Failures) == 0 {
+		lines = append(lines, "  no failed operations were reported by ARM")
+	}
+	for _, failure := range deploymentErr.Failures {
+		lines = append(lines, fmt.Sprintf("  resource: %s/%s", failure.ResourceType, failure.ResourceName))
+		lines = append(lines, fmt.Sprintf("    status: %s", failure.StatusCode))
+		if !failure.Timestamp.IsZero() {
+			lines = append(lines, fmt.Sprintf("    time:   %s", failure.Timestamp.Format(time.RFC3339)))
+		}
+		for _, detail := range failure.Details {
+			line := fmt.Sprintf("    %s: %s", detail.Code, detail.Message)
+			if detail.Target != "" {
+				line = fmt.Sprintf("%s (target: %s)", line, detail.Target)
+			}
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func SaveDeploymentError(outputDirectory string, deploymentErr *DeploymentError) error {
+	contents, err := json.MarshalIndent(deploymentErr, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return SaveDeploymentFile(outputDirectory, DeploymentErrorFilename, string(contents), 0600)
+}
+
+func newDeploymentError(resourceGroupName, deploymentName string, deployErr error, operations []resources.DeploymentOperation) *DeploymentError {
+	deploymentErr := &DeploymentError{
+		ResourceGroup:  resourceGroupName,
+		DeploymentName: deploymentName,
+		Message:        deployErr.Error(),
+		Failures:       []DeploymentFailure{},
+		Err:            deployErr,
+	}
+
+	for _, operation := range operations {
+		properties := operation.Properties
+		if properties == nil || to.String(properties.ProvisioningState) != "Failed" {
+			continue
+		}
+
+		failure := DeploymentFailure{
+			StatusCode: to.String(properties.StatusCode),
+		}
+		if properties.TargetResource != nil {
+			failure.ResourceType = to.String(properties.TargetResource.ResourceType)
+			failure.ResourceName = to.String(properties.TargetResource.ResourceName)
+		}
+		if properties.Timestamp != nil {
+			failure.Timestamp = properties.Timestamp.Time
+		}
+		if properties.StatusMessage != nil {
+			failure.StatusMessage = *properties.StatusMessage
+			failure.Details = collectArmErrorDetails(failure.StatusMessage)
+		}
+		deploymentErr.Failures = append(deploymentErr.Failures, failure)
+	}
+
+	return deploymentErr
+}
+
+// collectArmErrorDetails flattens the nested "error" and "details" objects
+// of a statusMessage, outermost first.
+func collectArmErrorDetails(value interface{}) []ArmErrorDetail {
+	var details []ArmErrorDetail
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		code, _ := v["code"].(string)
+		message, _ := v["message"].(string)
+		target, _ := v["target"].(string)
+		if code != "" || message != "" {
+			details = append(details, ArmErrorDetail{Code: code, Message: message, Target: target})
+		}
+		details = append(details, collectArmErrorDetails(v["error"])...)
+		details = append(details, collectArmErrorDetails(v["details"])...)
+	case []interface{}:
+		for _, item := range v {
+			details = append(details, collectArmErrorDetails(item)...)
+		}
+	}
+
+	return details
+}
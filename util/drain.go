@@ -0,0 +1,123 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	k8sapi "k8s.io/kubernetes/pkg/api"
+	k8s "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/fields"
+)
+
+const (
+	drainPollInterval = 5 * time.Second
+	drainTimeout      = 5 * time.Minute
+
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+	createdByAnnotation = "kubernetes.io/created-by"
+)
+
+func CordonNode(c *k8s.Client, nodeName string) error {
+	node, err := c.Nodes().Get(nodeName)
+	if err != nil {
+		return err
+	}
+	if node.Spec.Unschedulable {
+		return nil
+	}
+
+	log.Infof("drain: cordoning node. node=%q", nodeName)
+	node.Spec.Unschedulable = true
+	_, err = c.Nodes().Update(node)
+	return err
+}
+
+func UncordonNode(c *k8s.Client, nodeName string) error {
+	node, err := c.Nodes().Get(nodeName)
+	if err != nil {
+		return err
+	}
+	if !node.Spec.Unschedulable {
+		return nil
+	}
+
+	log.Infof("drain: uncordoning node. node=%q", nodeName)
+	node.Spec.Unschedulable = false
+	_, err = c.Nodes().Update(node)
+	return err
+}
+
+// DrainNode cordons a node and deletes the pods running on it, the way
+// `kubectl drain` does, then waits for them to terminate. Mirror pods and
+// pods managed by a DaemonSet are left alone since deleting them is futile.
+func DrainNode(c *k8s.Client, nodeName string, cancel <-chan struct{}) error {
+	err := CordonNode(c, nodeName)
+	if err != nil {
+		return err
+	}
+
+	pods, err := drainablePods(c, nodeName)
+	if err != nil {
+		return err
+	}
+
+	for _, pod := range pods {
+		log.Infof("drain: evicting pod. node=%q pod=%s/%s", nodeName, pod.Namespace, pod.Name)
+		err = c.Pods(pod.Namespace).Delete(pod.Name, nil)
+		if err != nil {
+			return fmt.Errorf("drain: failed to delete pod %s/%s: %q", pod.Namespace, pod.Name, err)
+		}
+	}
+
+	deadline := time.After(drainTimeout)
+	for {
+		remaining, err := drainablePods(c, nodeName)
+		if err != nil {
+			return err
+		}
+		if len(remaining) == 0 {
+			log.Infof("drain: node drained. node=%q", nodeName)
+			return nil
+		}
+
+		log.Debugf("drain: waiting for pods to terminate. node=%q remaining=%d", nodeName, len(remaining))
+		select {
+		case <-cancel:
+			return fmt.Errorf("drain: canceled")
+		case <-deadline:
+			return fmt.Errorf("drain: timed out waiting for %d pod(s) to leave node %q", len(remaining), nodeName)
+		case <-time.After(drainPollInterval):
+		}
+	}
+}
+
+func DeleteNode(c *k8s.Client, nodeName string) error {
+	log.Infof("drain: deleting node object. node=%q", nodeName)
+	return c.Nodes().Delete(nodeName)
+}
+
+func drainablePods(c *k8s.Client, nodeName string) ([]k8sapi.Pod, error) {
+	podList, err := c.Pods(k8sapi.NamespaceAll).List(k8sapi.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []k8sapi.Pod
+	for _, pod := range podList.Items {
+		if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+			continue
+		}
+		if strings.Contains(pod.Annotations[createdByAnnotation], `"kind":"DaemonSet"`) {
+			continue
+		}
+		if pod.Status.Phase == k8sapi.PodSucceeded || pod.Status.Phase == k8sapi.PodFailed {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+const (
+	DefaultEnvironmentName = "AzurePublicCloud"
+)
+
+// Environment extends the autorest environment with the endpoints azkube
+// needs that autorest does not know about.
+type Environment struct {
+	azure.Environment
+
+	CloudAppDNSSuffix string `json:"cloudAppDnsSuffix"`
+}
+
+var (
+	GermanCloud = azure.Environment{
+		Name:                      "AzureGermanCloud",
+		ManagementPortalURL:       "http://portal.microsoftazure.de/",
+		PublishSettingsURL:        "https://manage.microsoftazure.de/publishsettings/index",
+		ServiceManagementEndpoint: "https://management.core.cloudapi.de/",
+		ResourceManagerEndpoint:   "https://management.microsoftazure.de/",
+		ActiveDirectoryEndpoint:   "https://login.microsoftonline.de/",
+		GalleryEndpoint:           "https://gallery.cloudapi.de/",
+		KeyVaultEndpoint:          "https://vault.microsoftazure.de/",
+		GraphEndpoint:             "https://graph.cloudapi.de/",
+		StorageEndpointSuffix:     "core.cloudapi.de",
+		SQLDatabaseDNSSuffix:      "database.cloudapi.de",
+		TrafficManagerDNSSuffix:   "azuretrafficmanager.de",
+		KeyVaultDNSSuffix:         "vault.microsoftazure.de",
+		ServiceBusEndpointSuffix:  "servicebus.cloudapi.de",
+	}
+
+	Environments = map[string]Environment{
+		"azurepubliccloud":       {Environment: azure.PublicCloud, CloudAppDNSSuffix: "cloudapp.azure.com"},
+		"azurechinacloud":        {Environment: azure.ChinaCloud, CloudAppDNSSuffix: "cloudapp.chinacloudapi.cn"},
+		"azuregermancloud":       {Environment: GermanCloud, CloudAppDNSSuffix: "cloudapp.microsoftazure.de"},
+		"azureusgovernmentcloud": {Environment: azure.USGovernmentCloud, CloudAppDNSSuffix: "cloudapp.usgovcloudapi.net"},
+	}
+)
+
+func EnvironmentFromName(name string) (Environment, error) {
+	if name == "" {
+		name = DefaultEnvironmentName
+	}
+
+	environment, ok := Environments[strings.ToLower(name)]
+	if !ok {
+		return Environment{}, fmt.Errorf("environment: unknown azure environment %q", name)
+	}
+	return environment, nil
+}
+
+// EnvironmentFromFile loads a custom set of endpoints (such as an Azure Stack
+// installation) from a JSON file using the same field names as Environment.
+func EnvironmentFromFile(path string) (Environment, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Environment{}, fmt.Errorf("environment: failed to read %q: %q", path, err)
+	}
+
+	var environment Environment
+	err = json.Unmarshal(contents, &environment)
+	if err != nil {
+		return Environment{}, fmt.Errorf("environment: failed to parse %q: %q", path, err)
+	}
+
+	required := map[string]string{
+		"name":                      environment.Name,
+		"serviceManagementEndpoint": environment.ServiceManagementEndpoint,
+		"resourceManagerEndpoint":   environment.ResourceManagerEndpoint,
+		"activeDirectoryEndpoint":   environment.ActiveDirectoryEndpoint,
+		"graphEndpoint":             environment.GraphEndpoint,
+		"storageEndpointSuffix":     environment.StorageEndpointSuffix,
+		"cloudAppDnsSuffix":         environment.CloudAppDNSSuffix,
+	}
+	for field, value := range required {
+		if value == "" {
+			return Environment{}, fmt.Errorf("environment: %q is missing required field %q", path, field)
+		}
+	}
+
+	return environment, nil
+}
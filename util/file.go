@@ -7,6 +7,17 @@ import (
 	"path"
 )
 
+func DeploymentFileExists(directory, filename string) (bool, error) {
+	_, err := os.Stat(path.Join(directory, filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func SaveDeploymentFile(directory, filename, contents string, filemode os.FileMode) error {
 	return ioutil.WriteFile(
 		path.Join(directory, filename),
@@ -10,9 +10,10 @@ type FlavorArguments struct {
 
 	TenantID string
 
+	StorageEndpointSuffix string
+
 	MasterSize       string
-	NodeSize         string
-	NodeCount        int
+	NodePools        []NodePool
 	Username         string
 	SshPublicKeyData string
 
@@ -26,6 +27,11 @@ type FlavorArguments struct {
 	KubernetesReleaseURL    string
 	KubernetesHyperkubeSpec string
 
+	Tags map[string]string
+
+	// NodeCount is the number of Ready nodes that validation waits for.
+	NodeCount int
+
 	CAKeyPair        *PkiKeyCertPair
 	ApiserverKeyPair *PkiKeyCertPair
 	ClientKeyPair    *PkiKeyCertPair
@@ -2,11 +2,18 @@ package util
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/http"
 
 	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+	"github.com/Azure/go-autorest/autorest"
 	log "github.com/Sirupsen/logrus"
 )
 
+const (
+	resourceGroupsAPIVersion = "2016-02-01"
+)
+
 func (azureClient *AzureClient) EnsureResourceGroup(name, location string) (resourceGroup *resources.ResourceGroup, err error) {
 	log.Debugf("Ensuring resource group exists. resourcegroup=%q", name)
 	response, err := azureClient.GroupsClient.CreateOrUpdate(name, resources.ResourceGroup{
@@ -45,3 +52,87 @@ func (azureClient *AzureClient) ListResources(resourceGroup string) (*[]resource
 
 	return &allResources, nil
 }
+
+func (azureClient *AzureClient) ListResourceGroupsWithTag(tagName string) ([]resources.ResourceGroup, error) {
+	var allGroups []resources.ResourceGroup
+
+	filter := fmt.Sprintf("tagname eq '%s'", tagName)
+	groupList, err := azureClient.GroupsClient.List(filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if groupList.Value != nil {
+			allGroups = append(allGroups, *groupList.Value...)
+		}
+		if groupList.NextLink == nil || *groupList.NextLink == "" {
+			break
+		}
+
+		groupList, err = azureClient.GroupsClient.ListNextResults(groupList)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return allGroups, nil
+}
+
+// BeginDeleteResourceGroup starts the deletion of a resource group without
+// waiting for it, and returns the url that reports its progress.
+func (azureClient *AzureClient) BeginDeleteResourceGroup(name string) (string, error) {
+	q := map[string]interface{}{"api-version": resourceGroupsAPIVersion}
+
+	req, err := autorest.Prepare(&http.Request{},
+		autorest.AsDelete(),
+		autorest.WithBaseURL(azureClient.Environment.ResourceManagerEndpoint),
+		autorest.WithPath(fmt.Sprintf("subscriptions/%s/resourcegroups/%s", azureClient.SubscriptionID, name)),
+		autorest.WithQueryParameters(q),
+		azureClient.GroupsClient.WithAuthorization())
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := autorest.SendWithSender(azureClient.GroupsClient, req)
+	if err != nil {
+		return "", err
+	}
+
+	err = autorest.Respond(
+		resp,
+		autorest.WithErrorUnlessStatusCode(http.StatusOK, http.StatusAccepted),
+		autorest.ByClosing())
+	if err != nil {
+		return "", err
+	}
+	return autorest.GetLocation(resp), nil
+}
+
+// OperationDone polls the status url of an asynchronous operation once. It
+// reports false while the operation is still running and an error if it
+// failed.
+func (azureClient *AzureClient) OperationDone(statusURL string) (bool, error) {
+	req, err := autorest.Prepare(&http.Request{},
+		autorest.AsGet(),
+		autorest.WithBaseURL(statusURL),
+		azureClient.GroupsClient.WithAuthorization())
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := autorest.SendWithSender(azureClient.GroupsClient, req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		return false, nil
+	case http.StatusOK, http.StatusNoContent:
+		return true, nil
+	default:
+		body, _ := ioutil.ReadAll(resp.Body)
+		return false, fmt.Errorf("operation failed. status=%d body=%q", resp.StatusCode, string(body))
+	}
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/arm/resources/locks"
+	"github.com/Azure/go-autorest/autorest/to"
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// ProtectLockName is the name of the lock that deploy --protect and the
+	// protect command put on a cluster's resource group.
+	ProtectLockName = "azkube-protect"
+
+	locksAPIVersion = "2015-01-01"
+
+	lockIDSeparator = "/providers/microsoft.authorization/locks/"
+)
+
+// ManagementLock is a lock on a resource group or on one of its resources.
+// Scope is the id of the resource group or resource it is on.
+type ManagementLock struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+	Level string `json:"level"`
+	Notes string `json:"notes,omitempty"`
+}
+
+// OnResourceGroup reports whether the lock is on the resource group itself
+// rather than on one of its resources.
+func (lock ManagementLock) OnResourceGroup() bool {
+	return !strings.Contains(strings.ToLower(lock.Scope), "/providers/")
+}
+
+// IsProtectLock reports whether the lock is the one ProtectResourceGroup
+// adds.
+func (lock ManagementLock) IsProtectLock() bool {
+	return lock.Name == ProtectLockName && lock.OnResourceGroup()
+}
+
+// lockScope cuts the lock's own path off its id.
+func lockScope(id string) string {
+	if i := strings.LastIndex(strings.ToLower(id), lockIDSeparator); i >= 0 {
+		return id[:i]
+	}
+	return id
+}
+
+// ProtectResourceGroup puts a CanNotDelete lock on a resource group.
+func (azureClient *AzureClient) ProtectResourceGroup(resourceGroupName, deploymentName string) error {
+	log.Infof("Locking resource group against deletion. resourceGroup=%q lock=%q", resourceGroupName, ProtectLockName)
+	_, err := azureClient.ManagementLocksClient.CreateOrUpdateAtResourceGroupLevel(resourceGroupName, ProtectLockName, locks.ManagementLockObject{
+		Name: to.StringPtr(ProtectLockName),
+		Properties: &locks.ManagementLockProperties{
+			Level: locks.CanNotDelete,
+			Notes: to.StringPtr(fmt.Sprintf("Protects the Kubernetes cluster %q. Remove with `azkube unprotect`.", deploymentName)),
+		},
+	})
+	return err
+}
+
+// ListResourceGroupLocks returns the locks on a resource group and on the
+// resources in it, any of which prevent it from being deleted.
+func (azureClient *AzureClient) ListResourceGroupLocks(resourceGroupName string) ([]ManagementLock, error) {
+	result, err := azureClient.ManagementLocksClient.ListAtResourceGroupLevel(resourceGroupName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	managementLocks := []ManagementLock{}
+	for {
+		if result.Value != nil {
+			for _, lock := range *result.Value {
+				managementLock := ManagementLock{
+					ID:    to.String(lock.ID),
+					Name:  to.String(lock.Name),
+					Scope: lockScope(to.String(lock.ID)),
+				}
+				if lock.Properties != nil {
+					managementLock.Level = string(lock.Properties.Level)
+					managementLock.Notes = to.String(lock.Properties.Notes)
+				}
+				managementLocks = append(managementLocks, managementLock)
+			}
+		}
+		if result.NextLink == nil || *result.NextLink == "" {
+			return managementLocks, nil
+		}
+		result, err = azureClient.ManagementLocksClient.ListAtResourceGroupLevelNextResults(result)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// DeleteLock removes a lock, wherever it is scoped.
+func (azureClient *AzureClient) DeleteLock(lock ManagementLock) error {
+	log.Warnf("Removing management lock. lock=%q level=%q id=%q", lock.Name, lock.Level, lock.ID)
+	return azureClient.deleteByID(lock.ID, locksAPIVersion, nil)
+}
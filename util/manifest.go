@@ -0,0 +1,166 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"time"
+)
+
+const (
+	ManifestFilename = "azkube.json"
+	ManifestVersion  = 2
+
+	StepResourceGroup    = "resourceGroup"
+	StepServicePrincipal = "servicePrincipal"
+	StepRoleAssignment   = "roleAssignment"
+	StepArmDeployment    = "armDeployment"
+	StepValidation       = "validation"
+
+	JournalResourceGroup    = "resourceGroup"
+	JournalApplication      = "application"
+	JournalServicePrincipal = "servicePrincipal"
+	JournalRoleAssignment   = "roleAssignment"
+)
+
+// JournalEntry records an Azure or directory resource that azkube created for
+// a deployment, as opposed to one that already existed. ID is whatever is
+// needed to delete it again: a name, an object id or a resource id.
+type JournalEntry struct {
+	Kind    string    `json:"kind"`
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+}
+
+// DeploymentManifest records everything needed to operate on a deployment
+// after the fact, so that later commands only need the output directory.
+type DeploymentManifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+
+	SubscriptionID string       `json:"subscriptionId"`
+	TenantID       string       `json:"tenantId"`
+	Environment    *Environment `json:"environment,omitempty"`
+
+	Flavor         string `json:"flavor"`
+	DeploymentName string `json:"deploymentName"`
+	ResourceGroup  string `json:"resourceGroup"`
+	Location       string `json:"location"`
+
+	MasterSize              string     `json:"masterSize"`
+	NodePools               []NodePool `json:"nodePools"`
+	Username                string     `json:"username"`
+	MasterFQDN              string     `json:"masterFqdn"`
+	MasterPrivateIP         string     `json:"masterPrivateIp"`
+	ClusterDomain           string     `json:"clusterDomain"`
+	MasterExtraFQDNs        []string   `json:"masterExtraFqdns,omitempty"`
+	KubernetesHyperkubeSpec string     `json:"kubernetesHyperkubeSpec"`
+
+	ServicePrincipalPassthrough  bool   `json:"servicePrincipalPassthrough"`
+	NoCloudProvider              bool   `json:"noCloudProvider"`
+	ApplicationID                string `json:"applicationId,omitempty"`
+	ServicePrincipalClientID     string `json:"servicePrincipalClientId,omitempty"`
+	ServicePrincipalClientSecret string `json:"servicePrincipalClientSecret,omitempty"`
+
+	// Protected is set while the resource group has azkube's CanNotDelete
+	// management lock.
+	Protected bool `json:"protected,omitempty"`
+
+	// Stopped is set while the cluster's vms are deallocated by stop.
+	Stopped bool `json:"stopped,omitempty"`
+
+	// CompletedSteps lists the deploy steps that have finished, so that an
+	// interrupted deploy can be resumed without repeating them.
+	CompletedSteps []string `json:"completedSteps,omitempty"`
+
+	// Journal lists the resources created for this deployment, in creation
+	// order, so that a failed deploy can be rolled back.
+	Journal []JournalEntry `json:"journal,omitempty"`
+}
+
+// legacyNodes holds the single node pool of version 1 manifests, written
+// before node pools existed.
+type legacyNodes struct {
+	NodeSize  string `json:"nodeSize"`
+	NodeCount int    `json:"nodeCount"`
+}
+
+func (manifest *DeploymentManifest) StepCompleted(step string) bool {
+	for _, completed := range manifest.CompletedSteps {
+		if completed == step {
+			return true
+		}
+	}
+	return false
+}
+
+func (manifest *DeploymentManifest) CompleteStep(step string) {
+	if !manifest.StepCompleted(step) {
+		manifest.CompletedSteps = append(manifest.CompletedSteps, step)
+	}
+}
+
+func (manifest *DeploymentManifest) UncompleteStep(step string) {
+	for i, completed := range manifest.CompletedSteps {
+		if completed == step {
+			manifest.CompletedSteps = append(manifest.CompletedSteps[:i], manifest.CompletedSteps[i+1:]...)
+			return
+		}
+	}
+}
+
+func (manifest *DeploymentManifest) RecordCreated(kind, id string) {
+	manifest.Journal = append(manifest.Journal, JournalEntry{
+		Kind:    kind,
+		ID:      id,
+		Created: time.Now().UTC(),
+	})
+}
+
+func ManifestExists(outputDirectory string) (bool, error) {
+	return DeploymentFileExists(outputDirectory, ManifestFilename)
+}
+
+func SaveManifest(outputDirectory string, manifest *DeploymentManifest) error {
+	manifest.Version = ManifestVersion
+
+	contents, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return SaveDeploymentFile(outputDirectory, ManifestFilename, string(contents), 0600)
+}
+
+func LoadManifest(outputDirectory string) (*DeploymentManifest, error) {
+	manifestPath := path.Join(outputDirectory, ManifestFilename)
+
+	contents, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("manifest: failed to read %q: %q", manifestPath, err)
+	}
+
+	var manifest DeploymentManifest
+	err = json.Unmarshal(contents, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("manifest: failed to parse %q: %q", manifestPath, err)
+	}
+
+	if manifest.Version > ManifestVersion {
+		return nil, fmt.Errorf("manifest: unsupported version. path=%q version=%d supported=%d", manifestPath, manifest.Version, ManifestVersion)
+	}
+
+	if manifest.Version < 2 {
+		var legacy legacyNodes
+		err = json.Unmarshal(contents, &legacy)
+		if err != nil {
+			return nil, fmt.Errorf("manifest: failed to parse %q: %q", manifestPath, err)
+		}
+		if legacy.NodeSize != "" {
+			manifest.NodePools = []NodePool{{Name: DefaultNodePoolName, Size: legacy.NodeSize, Count: legacy.NodeCount}}
+		}
+	}
+
+	return &manifest, nil
+}
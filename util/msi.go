@@ -0,0 +1,150 @@
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultMSIEndpoint is the token endpoint of the instance metadata
+	// service, reachable from inside Azure vms only.
+	DefaultMSIEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+	msiAPIVersion    = "2018-02-01"
+	msiRefreshWithin = 5 * time.Minute
+)
+
+// MSIToken is an autorest.Authorizer that gets the tokens of the vm's managed
+// service identity from the instance metadata service, and gets a new one
+// when it is about to expire. ClientID selects a user assigned identity; the
+// system assigned identity is used when it is empty.
+type MSIToken struct {
+	Endpoint string
+	Resource string
+	ClientID string
+
+	// Sender sends the token requests. They are sent with
+	// DefaultRetryPolicy if it is nil.
+	Sender autorest.Sender
+
+	mu    sync.Mutex
+	token azure.Token
+}
+
+func NewClientWithMSI(azureEnvironment Environment, subscriptionID, tenantID, clientID, endpoint string, retryPolicy RetryPolicy) (*AzureClient, error) {
+	oauthConfig, err := azureEnvironment.OAuthConfigForTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	azureClient := AzureClient{
+		Environment:    azureEnvironment,
+		OAuthConfig:    *oauthConfig,
+		TenantID:       tenantID,
+		SubscriptionID: subscriptionID,
+		ClientID:       clientID,
+		RetryPolicy:    retryPolicy,
+	}
+
+	if endpoint == "" {
+		endpoint = DefaultMSIEndpoint
+	}
+	sender := retryPolicy.Sender()
+	armToken := &MSIToken{Endpoint: endpoint, Resource: azureEnvironment.ServiceManagementEndpoint, ClientID: clientID, Sender: sender}
+	err = armToken.Refresh()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get a managed service identity token from %q: %q", endpoint, err)
+	}
+	adToken := &MSIToken{Endpoint: endpoint, Resource: azureEnvironment.GraphEndpoint, ClientID: clientID, Sender: sender}
+
+	return azureClient.build(armToken, adToken)
+}
+
+// Refresh gets a new token from the endpoint.
+func (t *MSIToken) Refresh() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.refresh()
+}
+
+// EnsureFresh gets a new token if the current one expires soon.
+func (t *MSIToken) EnsureFresh() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token.AccessToken != "" && !t.token.WillExpireIn(msiRefreshWithin) {
+		return nil
+	}
+	return t.refresh()
+}
+
+// AccessToken returns a current access token.
+func (t *MSIToken) AccessToken() (string, error) {
+	err := t.EnsureFresh()
+	if err != nil {
+		return "", err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.token.AccessToken, nil
+}
+
+func (t *MSIToken) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			accessToken, err := t.AccessToken()
+			if err != nil {
+				return r, err
+			}
+			return autorest.WithBearerAuthorization(accessToken)(p).Prepare(r)
+		})
+	}
+}
+
+func (t *MSIToken) refresh() error {
+	q := map[string]interface{}{
+		"api-version": msiAPIVersion,
+		"resource":    t.Resource,
+	}
+	if t.ClientID != "" {
+		q["client_id"] = t.ClientID
+	}
+
+	req, err := autorest.Prepare(&http.Request{},
+		autorest.AsGet(),
+		autorest.WithBaseURL(t.Endpoint),
+		autorest.WithQueryParameters(q),
+		autorest.WithHeader("Metadata", "true"))
+	if err != nil {
+		return err
+	}
+
+	sender := t.Sender
+	if sender == nil {
+		sender = DefaultRetryPolicy.Sender()
+	}
+	resp, err := autorest.SendWithSender(sender, req)
+	if err != nil {
+		return err
+	}
+
+	var token azure.Token
+	err = autorest.Respond(
+		resp,
+		autorest.WithErrorUnlessOK(),
+		autorest.ByUnmarshallingJSON(&token),
+		autorest.ByClosing())
+	if err != nil {
+		return err
+	}
+
+	t.token = token
+	log.Debugf("Got managed service identity token. resource=%q", t.Resource)
+	return nil
+}
@@ -0,0 +1,191 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultNodePoolName is the pool that --node-size and --node-count
+	// describe. Its scale set keeps the name used before pools existed.
+	DefaultNodePoolName = "node"
+
+	// NodePoolLabel is set on every node to the name of its pool.
+	NodePoolLabel = "azkube/pool"
+
+	// masterNodeName is the part of the master's vm name where a pool's
+	// name would be. A pool's node names must not be confused with it.
+	masterNodeName = "master"
+)
+
+var (
+	nodePoolNameRegex = regexp.MustCompile(`^[a-z][a-z0-9]{0,8}$`)
+	labelKeyRegex     = regexp.MustCompile(`^([a-z0-9]([-a-z0-9.]*[a-z0-9])?/)?[A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?$`)
+	labelValueRegex   = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?)?$`)
+	taintRegex        = regexp.MustCompile(`^[A-Za-z0-9][-A-Za-z0-9_./]*=[-A-Za-z0-9_.]*:(NoSchedule|PreferNoSchedule)$`)
+	hyperkubeTagRegex = regexp.MustCompile(`:v(\d+)\.(\d+)`)
+)
+
+const (
+	// kubelet got --register-with-taints in 1.6
+	taintsMinMajor = 1
+	taintsMinMinor = 6
+)
+
+// NodePool is a group of identical nodes backed by one vm scale set.
+type NodePool struct {
+	Name   string            `json:"name"`
+	Size   string            `json:"size"`
+	Count  int               `json:"count"`
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Taints are registered by the kubelet, in the form key=value:effect.
+	Taints []string `json:"taints,omitempty"`
+}
+
+// armNodePool is the shape of an entry of the nodePools template parameter.
+type armNodePool struct {
+	Name         string `json:"name"`
+	Size         string `json:"size"`
+	Count        int    `json:"count"`
+	KubeletFlags string `json:"kubeletFlags"`
+}
+
+// NodeScaleSetName returns the name the cluster template gives to the scale
+// set of a node pool.
+func NodeScaleSetName(deploymentName, pool string) string {
+	return fmt.Sprintf("%s-vm-%s-scaleset", deploymentName, pool)
+}
+
+// NodeNamePrefix returns the prefix shared by the Kubernetes node names of a
+// node pool's scale set instances.
+func NodeNamePrefix(deploymentName, pool string) string {
+	return strings.ToLower(fmt.Sprintf("%s-vm-%s", deploymentName, pool))
+}
+
+func (pool NodePool) Validate() error {
+	if !nodePoolNameRegex.MatchString(pool.Name) {
+		return fmt.Errorf("nodepool: name must be 1-9 lowercase letters and digits, starting with a letter: %q", pool.Name)
+	}
+	if strings.HasPrefix(masterNodeName, pool.Name) || strings.HasPrefix(pool.Name, masterNodeName) {
+		return fmt.Errorf("nodepool: name must not be a prefix of %q or start with it, so the pool's nodes can be told apart from the master: %q", masterNodeName, pool.Name)
+	}
+	if pool.Size == "" {
+		return fmt.Errorf("nodepool: size must be set. pool=%q", pool.Name)
+	}
+	if pool.Count < 0 {
+		return fmt.Errorf("nodepool: count must not be negative. pool=%q", pool.Name)
+	}
+	for key, value := range pool.Labels {
+		if !labelKeyRegex.MatchString(key) || !labelValueRegex.MatchString(value) {
+			return fmt.Errorf("nodepool: invalid label. pool=%q label=%q", pool.Name, key+"="+value)
+		}
+		if key == NodePoolLabel {
+			return fmt.Errorf("nodepool: label %q is reserved. pool=%q", NodePoolLabel, pool.Name)
+		}
+	}
+	for _, taint := range pool.Taints {
+		if !taintRegex.MatchString(taint) {
+			return fmt.Errorf("nodepool: taints must look like key=value:NoSchedule. pool=%q taint=%q", pool.Name, taint)
+		}
+	}
+	return nil
+}
+
+// ValidateNodePools checks each pool and that their node names can be told
+// apart, which requires that no pool name is a prefix of another.
+func ValidateNodePools(pools []NodePool) error {
+	if len(pools) == 0 {
+		return fmt.Errorf("nodepool: at least one node pool is required")
+	}
+	for i, pool := range pools {
+		err := pool.Validate()
+		if err != nil {
+			return err
+		}
+		for _, other := range pools[i+1:] {
+			if strings.HasPrefix(pool.Name, other.Name) || strings.HasPrefix(other.Name, pool.Name) {
+				return fmt.Errorf("nodepool: pool names must not be prefixes of each other: %q, %q", pool.Name, other.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateNodePoolTaints rejects taints when the hyperkube image is a
+// Kubernetes version whose kubelet would refuse to start with
+// --register-with-taints. Images without a version tag are assumed to be
+// recent enough.
+func ValidateNodePoolTaints(pools []NodePool, hyperkubeSpec string) error {
+	m := hyperkubeTagRegex.FindStringSubmatch(hyperkubeSpec)
+	if m == nil {
+		return nil
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	if major > taintsMinMajor || (major == taintsMinMajor && minor >= taintsMinMinor) {
+		return nil
+	}
+
+	for _, pool := range pools {
+		if len(pool.Taints) > 0 {
+			return fmt.Errorf("nodepool: taints require Kubernetes v%d.%d or later, the kubelet of %q doesn't support --register-with-taints. pool=%q", taintsMinMajor, taintsMinMinor, hyperkubeSpec, pool.Name)
+		}
+	}
+	return nil
+}
+
+// KubeletFlags returns the flags that register a pool's nodes with its
+// labels and taints.
+func (pool NodePool) KubeletFlags() string {
+	labels := []string{NodePoolLabel + "=" + pool.Name}
+	for key, value := range pool.Labels {
+		labels = append(labels, key+"="+value)
+	}
+	sort.Strings(labels)
+
+	flags := "--node-labels=" + strings.Join(labels, ",")
+	if len(pool.Taints) > 0 {
+		flags += " --register-with-taints=" + strings.Join(pool.Taints, ",")
+	}
+	return flags
+}
+
+func FindNodePool(pools []NodePool, name string) (int, bool) {
+	for i, pool := range pools {
+		if pool.Name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func TotalNodeCount(pools []NodePool) int {
+	count := 0
+	for _, pool := range pools {
+		count += pool.Count
+	}
+	return count
+}
+
+// NodePoolsJSON renders the pools as the nodePools template parameter.
+func (flavorArgs FlavorArguments) NodePoolsJSON() (string, error) {
+	pools := []armNodePool{}
+	for _, pool := range flavorArgs.NodePools {
+		pools = append(pools, armNodePool{
+			Name:         pool.Name,
+			Size:         pool.Size,
+			Count:        pool.Count,
+			KubeletFlags: pool.KubeletFlags(),
+		})
+	}
+	contents, err := json.Marshal(pools)
+	if err != nil {
+		return "", err
+	}
+	return string(contents), nil
+}
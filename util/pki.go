@@ -6,8 +6,10 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"fmt"
+	"io/ioutil"
 	"math/big"
 	"net"
+	"path"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -29,27 +31,54 @@ func CreateSavePki(masterFQDN string, extraFQDNs []string, clusterDomain string,
 		return nil, nil, nil, err
 	}
 
-	err = SaveDeploymentFile(outputDirectory, "ca.key", (*ca).PrivateKeyPem, 0600)
+	err = savePki(outputDirectory, ca, apiserver, client)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	err = SaveDeploymentFile(outputDirectory, "ca.crt", (*ca).CertificatePem, 0600)
+
+	return ca, apiserver, client, nil
+}
+
+// LoadOrCreateSavePki reuses the PKI already present in the output directory.
+// An existing CA is never rotated; missing leaf certificates are re-issued from it.
+func LoadOrCreateSavePki(masterFQDN string, extraFQDNs []string, clusterDomain string, extraIPs []net.IP, outputDirectory string) (*PkiKeyCertPair, *PkiKeyCertPair, *PkiKeyCertPair, error) {
+	ca, err := loadKeyCertPair(outputDirectory, "ca")
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	err = SaveDeploymentFile(outputDirectory, "apiserver.key", (*apiserver).PrivateKeyPem, 0600)
+	if ca == nil {
+		return CreateSavePki(masterFQDN, extraFQDNs, clusterDomain, extraIPs, outputDirectory)
+	}
+	log.Infof("pki: reusing existing certificate authority. path=%q", path.Join(outputDirectory, "ca.crt"))
+
+	apiserver, err := loadKeyCertPair(outputDirectory, "apiserver")
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	err = SaveDeploymentFile(outputDirectory, "apiserver.crt", (*apiserver).CertificatePem, 0600)
+	client, err := loadKeyCertPair(outputDirectory, "client")
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	err = SaveDeploymentFile(outputDirectory, "client.key", (*client).PrivateKeyPem, 0600)
+	if apiserver != nil && client != nil {
+		log.Infof("pki: reusing existing apiserver and client certificates")
+		return ca, apiserver, client, nil
+	}
+
+	caCertificate, err := PemToCertificate(ca.CertificatePem)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	err = SaveDeploymentFile(outputDirectory, "client.crt", (*client).CertificatePem, 0600)
+	caPrivateKey, err := PemToPrivateKey(ca.PrivateKeyPem)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	apiserver, client, err = createLeafPki(caCertificate, caPrivateKey, masterFQDN, extraFQDNs, extraIPs, clusterDomain)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	err = savePki(outputDirectory, ca, apiserver, client)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -57,7 +86,97 @@ func CreateSavePki(masterFQDN string, extraFQDNs []string, clusterDomain string,
 	return ca, apiserver, client, nil
 }
 
+// LoadClientPki loads the certificate authority and client credentials that
+// deploy saved to an output directory, for talking to the cluster's apiserver.
+func LoadClientPki(outputDirectory string) (ca, client *PkiKeyCertPair, err error) {
+	ca, err = loadKeyCertPair(outputDirectory, "ca")
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err = loadKeyCertPair(outputDirectory, "client")
+	if err != nil {
+		return nil, nil, err
+	}
+	if ca == nil || client == nil {
+		return nil, nil, fmt.Errorf("pki: ca and client credentials not found in %q", outputDirectory)
+	}
+	return ca, client, nil
+}
+
+func loadKeyCertPair(outputDirectory, name string) (*PkiKeyCertPair, error) {
+	keyExists, err := DeploymentFileExists(outputDirectory, name+".key")
+	if err != nil {
+		return nil, err
+	}
+	certExists, err := DeploymentFileExists(outputDirectory, name+".crt")
+	if err != nil {
+		return nil, err
+	}
+	if !keyExists && !certExists {
+		return nil, nil
+	}
+	if keyExists != certExists {
+		return nil, fmt.Errorf("pki: found only one of %s.key and %s.crt in %q", name, name, outputDirectory)
+	}
+
+	privateKeyPem, err := ioutil.ReadFile(path.Join(outputDirectory, name+".key"))
+	if err != nil {
+		return nil, err
+	}
+	certificatePem, err := ioutil.ReadFile(path.Join(outputDirectory, name+".crt"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &PkiKeyCertPair{CertificatePem: string(certificatePem), PrivateKeyPem: string(privateKeyPem)}, nil
+}
+
+func savePki(outputDirectory string, ca, apiserver, client *PkiKeyCertPair) error {
+	err := SaveDeploymentFile(outputDirectory, "ca.key", (*ca).PrivateKeyPem, 0600)
+	if err != nil {
+		return err
+	}
+	err = SaveDeploymentFile(outputDirectory, "ca.crt", (*ca).CertificatePem, 0600)
+	if err != nil {
+		return err
+	}
+	err = SaveDeploymentFile(outputDirectory, "apiserver.key", (*apiserver).PrivateKeyPem, 0600)
+	if err != nil {
+		return err
+	}
+	err = SaveDeploymentFile(outputDirectory, "apiserver.crt", (*apiserver).CertificatePem, 0600)
+	if err != nil {
+		return err
+	}
+	err = SaveDeploymentFile(outputDirectory, "client.key", (*client).PrivateKeyPem, 0600)
+	if err != nil {
+		return err
+	}
+	err = SaveDeploymentFile(outputDirectory, "client.crt", (*client).CertificatePem, 0600)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func CreatePki(masterFQDN string, extraFQDNs []string, extraIPs []net.IP, clusterDomain string) (*PkiKeyCertPair, *PkiKeyCertPair, *PkiKeyCertPair, error) {
+	log.Debug("pki: generating certificate authority")
+	caCertificate, caPrivateKey, err := createCertificate("ca", nil, nil, false, "", nil, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	apiserver, client, err := createLeafPki(caCertificate, caPrivateKey, masterFQDN, extraFQDNs, extraIPs, clusterDomain)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return &PkiKeyCertPair{CertificatePem: string(CertificateToPem(caCertificate.Raw)), PrivateKeyPem: string(PrivateKeyToPem(caPrivateKey))},
+		apiserver, client, nil
+}
+
+func createLeafPki(caCertificate *x509.Certificate, caPrivateKey *rsa.PrivateKey, masterFQDN string, extraFQDNs []string, extraIPs []net.IP, clusterDomain string) (*PkiKeyCertPair, *PkiKeyCertPair, error) {
 	extraFQDNs = append(extraFQDNs, fmt.Sprintf("kubernetes"))
 	extraFQDNs = append(extraFQDNs, fmt.Sprintf("kubernetes.default"))
 	extraFQDNs = append(extraFQDNs, fmt.Sprintf("kubernetes.default.svc"))
@@ -66,24 +185,18 @@ func CreatePki(masterFQDN string, extraFQDNs []string, extraIPs []net.IP, cluste
 	extraFQDNs = append(extraFQDNs, fmt.Sprintf("kubernetes.kube-system.svc"))
 	extraFQDNs = append(extraFQDNs, fmt.Sprintf("kubernetes.kube-system.svc.%s", clusterDomain))
 
-	log.Debug("pki: generating certificate authority")
-	caCertificate, caPrivateKey, err := createCertificate("ca", nil, nil, false, "", nil, nil)
-	if err != nil {
-		return nil, nil, nil, err
-	}
 	log.Debug("pki: generating apiserver server certificate")
 	apiserverCertificate, apiserverPrivateKey, err := createCertificate("apiserver", caCertificate, caPrivateKey, true, masterFQDN, extraFQDNs, extraIPs)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, err
 	}
 	log.Debug("pki: generating client certificate")
 	clientCertificate, clientPrivateKey, err := createCertificate("client", caCertificate, caPrivateKey, false, "", nil, nil)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, err
 	}
 
-	return &PkiKeyCertPair{CertificatePem: string(CertificateToPem(caCertificate.Raw)), PrivateKeyPem: string(PrivateKeyToPem(caPrivateKey))},
-		&PkiKeyCertPair{CertificatePem: string(CertificateToPem(apiserverCertificate.Raw)), PrivateKeyPem: string(PrivateKeyToPem(apiserverPrivateKey))},
+	return &PkiKeyCertPair{CertificatePem: string(CertificateToPem(apiserverCertificate.Raw)), PrivateKeyPem: string(PrivateKeyToPem(apiserverPrivateKey))},
 		&PkiKeyCertPair{CertificatePem: string(CertificateToPem(clientCertificate.Raw)), PrivateKeyPem: string(PrivateKeyToPem(clientPrivateKey))}, nil
 }
 
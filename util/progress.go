@@ -0,0 +1,142 @@
+package util
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+	"github.com/Azure/go-autorest/autorest/to"
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	deploymentProgressInterval = 10 * time.Second
+)
+
+type operationProgress struct {
+	resource string
+	state    string
+	started  time.Time
+	updated  time.Time
+}
+
+// deploymentProgress tracks the provisioning state of each resource in an ARM
+// deployment across polls of the deployment's operations.
+type deploymentProgress struct {
+	operations map[string]*operationProgress
+	order      []string
+}
+
+func newDeploymentProgress() *deploymentProgress {
+	return &deploymentProgress{operations: map[string]*operationProgress{}}
+}
+
+// watchDeployment logs provisioning state changes of a deployment's
+// operations until done is closed. It closes stopped once it no longer
+// touches progress.
+func (azureClient *AzureClient) watchDeployment(resourceGroupName, deploymentName string, progress *deploymentProgress, done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(deploymentProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := azureClient.pollDeploymentOperations(resourceGroupName, deploymentName, progress)
+			if err != nil {
+				log.Debugf("Failed to poll deployment operations (will retry): %q", err)
+			}
+		}
+	}
+}
+
+func (azureClient *AzureClient) pollDeploymentOperations(resourceGroupName, deploymentName string, progress *deploymentProgress) error {
+	operations, err := azureClient.listDeploymentOperations(resourceGroupName, deploymentName)
+	if err != nil {
+		return err
+	}
+
+	progress.updateAll(operations)
+	return nil
+}
+
+func (azureClient *AzureClient) listDeploymentOperations(resourceGroupName, deploymentName string) ([]resources.DeploymentOperation, error) {
+	var operations []resources.DeploymentOperation
+
+	result, err := azureClient.DeploymentOperationsClient.List(resourceGroupName, deploymentName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if result.Value != nil {
+			operations = append(operations, *result.Value...)
+		}
+		if result.NextLink == nil || *result.NextLink == "" {
+			return operations, nil
+		}
+
+		result, err = azureClient.DeploymentOperationsClient.ListNextResults(result)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (progress *deploymentProgress) updateAll(operations []resources.DeploymentOperation) {
+	for _, operation := range operations {
+		progress.update(operation)
+	}
+}
+
+func (progress *deploymentProgress) update(operation resources.DeploymentOperation) {
+	if operation.OperationID == nil || operation.Properties == nil {
+		return
+	}
+
+	properties := operation.Properties
+	state := to.String(properties.ProvisioningState)
+	timestamp := time.Now()
+	if properties.Timestamp != nil {
+		timestamp = properties.Timestamp.Time
+	}
+
+	op, ok := progress.operations[*operation.OperationID]
+	if !ok {
+		op = &operationProgress{
+			resource: operationResourceName(properties.TargetResource),
+			started:  timestamp,
+		}
+		progress.operations[*operation.OperationID] = op
+		progress.order = append(progress.order, *operation.OperationID)
+	}
+
+	if op.state == state {
+		return
+	}
+	op.state = state
+	op.updated = timestamp
+
+	log.Infof("[%s] %s: %s", timestamp.Format(time.RFC3339), op.resource, state)
+}
+
+func (progress *deploymentProgress) logSummary() {
+	if len(progress.order) == 0 {
+		return
+	}
+
+	log.Infof("Deployment operation summary:")
+	for _, id := range progress.order {
+		op := progress.operations[id]
+		log.Infof("  %-60s %-10s %s", op.resource, op.state, op.updated.Sub(op.started)/time.Second*time.Second)
+	}
+}
+
+func operationResourceName(target *resources.TargetResource) string {
+	if target == nil {
+		return "(deployment)"
+	}
+	return fmt.Sprintf("%s/%s", to.String(target.ResourceType), to.String(target.ResourceName))
+}
@@ -0,0 +1,148 @@
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// resourceAPIVersions are the api versions used to delete resources by
+	// id, per resource provider.
+	resourceAPIVersions = map[string]string{
+		"Microsoft.Compute": "2015-06-15",
+		"Microsoft.Network": "2015-06-15",
+		"Microsoft.Storage": "2015-06-15",
+	}
+
+	// resourceDeleteTiers orders the deletion of a cluster's resources so
+	// that nothing is deleted while another resource still refers to it.
+	// Types that are not listed are deleted last.
+	resourceDeleteTiers = map[string]int{
+		"Microsoft.Compute/virtualMachines":         0,
+		"Microsoft.Compute/virtualMachineScaleSets": 0,
+		"Microsoft.Network/networkInterfaces":       1,
+		"Microsoft.Network/publicIPAddresses":       1,
+		"Microsoft.Network/loadBalancers":           1,
+		"Microsoft.Network/networkSecurityGroups":   2,
+		"Microsoft.Network/virtualNetworks":         2,
+		"Microsoft.Storage/storageAccounts":         3,
+		"Microsoft.Compute/availabilitySets":        3,
+		"Microsoft.Network/routeTables":             3,
+	}
+)
+
+// StorageAccountName returns the name the cluster template gives to the
+// deployment's storage account, which does not share the name prefix of the
+// other resources.
+func StorageAccountName(deploymentName string) string {
+	return strings.Replace(deploymentName, "-", "", -1) + "strg"
+}
+
+// DeploymentOwnsResource reports whether a resource belongs to a deployment
+// by its azkube-deployment tag. Resources deployed before tags were set only
+// match by the exact names the cluster template gives them; pools are the
+// names of the deployment's node pools. Resources tagged for another
+// deployment never match.
+func DeploymentOwnsResource(resource resources.GenericResource, deploymentName string, pools []string) bool {
+	if resource.Tags != nil {
+		if owner, ok := (*resource.Tags)[TagDeployment]; ok && owner != nil {
+			return *owner == deploymentName
+		}
+	}
+
+	name := to.String(resource.Name)
+	for _, legacyName := range legacyResourceNames(deploymentName, pools) {
+		if name == legacyName {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyResourceNames lists the names of the resources the cluster template
+// creates for a deployment.
+func legacyResourceNames(deploymentName string, pools []string) []string {
+	names := []string{
+		MasterVMName(deploymentName),
+		deploymentName + "-nic-master",
+		deploymentName + "-pip-master",
+		deploymentName + "-nsg",
+		deploymentName + "-vnet",
+		StorageAccountName(deploymentName),
+	}
+	if len(pools) == 0 {
+		pools = []string{DefaultNodePoolName}
+	}
+	for _, pool := range pools {
+		names = append(names, NodeScaleSetName(deploymentName, pool))
+	}
+	return names
+}
+
+// SortResourcesForDeletion orders resources so that they can be deleted one
+// after the other: vms and scale sets, then nics and public ips, then
+// network security groups and vnets, then the storage account.
+func SortResourcesForDeletion(resourceList []resources.GenericResource) {
+	sort.Stable(byDeleteTier(resourceList))
+}
+
+type byDeleteTier []resources.GenericResource
+
+func (s byDeleteTier) Len() int           { return len(s) }
+func (s byDeleteTier) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byDeleteTier) Less(i, j int) bool { return deleteTier(s[i]) < deleteTier(s[j]) }
+
+func deleteTier(resource resources.GenericResource) int {
+	if tier, ok := resourceDeleteTiers[to.String(resource.Type)]; ok {
+		return tier
+	}
+	return len(resourceDeleteTiers)
+}
+
+// DeleteResource deletes a single resource by its id and waits for the
+// deletion to finish. Resources that are already gone are not an error.
+func (azureClient *AzureClient) DeleteResource(resource resources.GenericResource, cancel <-chan struct{}) error {
+	resourceType := to.String(resource.Type)
+	apiVersion, ok := resourceAPIVersions[strings.SplitN(resourceType, "/", 2)[0]]
+	if !ok {
+		return fmt.Errorf("resources: don't know how to delete a resource of type %q", resourceType)
+	}
+
+	log.Infof("Deleting resource. name=%q type=%q", to.String(resource.Name), resourceType)
+	return azureClient.deleteByID(to.String(resource.ID), apiVersion, cancel)
+}
+
+// deleteByID deletes an arm object by its id, waiting for asynchronous
+// deletions to finish.
+func (azureClient *AzureClient) deleteByID(id, apiVersion string, cancel <-chan struct{}) error {
+	q := map[string]interface{}{"api-version": apiVersion}
+
+	req, err := autorest.Prepare(&http.Request{Cancel: cancel},
+		autorest.AsDelete(),
+		autorest.WithBaseURL(azureClient.Environment.ResourceManagerEndpoint),
+		autorest.WithPath(id),
+		autorest.WithQueryParameters(q),
+		azureClient.ResourcesClient.WithAuthorization())
+	if err != nil {
+		return err
+	}
+
+	resp, err := autorest.SendWithSender(azureClient.ResourcesClient, req,
+		azure.DoPollForAsynchronous(azureClient.ResourcesClient.PollingDelay))
+	if err != nil {
+		return err
+	}
+
+	return autorest.Respond(
+		resp,
+		autorest.WithErrorUnlessStatusCode(http.StatusOK, http.StatusAccepted, http.StatusNoContent, http.StatusNotFound),
+		autorest.ByClosing())
+}
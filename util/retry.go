@@ -0,0 +1,127 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	log "github.com/Sirupsen/logrus"
+)
+
+// RetryPolicy decides how requests to Azure are retried. Throttled requests
+// (429) are always retried. Idempotent requests are also retried on
+// connection errors and on 408, 500, 502, 503 and 504 responses. Delays grow
+// exponentially from MinDelay with full jitter, up to MaxDelay, unless the
+// response says how long to wait with Retry-After.
+type RetryPolicy struct {
+	// MaxRetries is the number of times a request is retried. Zero disables
+	// retries.
+	MaxRetries int
+	MinDelay   time.Duration
+	MaxDelay   time.Duration
+}
+
+// DefaultRetryPolicy is the policy the command line flags default to.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 5,
+	MinDelay:   2 * time.Second,
+	MaxDelay:   60 * time.Second,
+}
+
+var (
+	idempotentMethods = map[string]bool{
+		"GET":     true,
+		"HEAD":    true,
+		"OPTIONS": true,
+		"PUT":     true,
+		"DELETE":  true,
+	}
+
+	transientStatusCodes = map[int]bool{
+		http.StatusRequestTimeout:      true,
+		http.StatusInternalServerError: true,
+		http.StatusBadGateway:          true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusGatewayTimeout:      true,
+	}
+)
+
+// Sender returns an autorest.Sender that sends requests with the policy.
+func (policy RetryPolicy) Sender() autorest.Sender {
+	return policy.Decorate(&http.Client{})
+}
+
+// Decorate returns an autorest.Sender that retries requests sent through s.
+func (policy RetryPolicy) Decorate(s autorest.Sender) autorest.Sender {
+	return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for attempt := 0; ; attempt++ {
+			if body != nil {
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+
+			resp, err := s.Do(r)
+			if attempt >= policy.MaxRetries || !policy.shouldRetry(r, resp, err) {
+				return resp, err
+			}
+
+			delay := policy.Delay(attempt)
+			if resp != nil {
+				if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+					delay = autorest.GetRetryAfter(resp, delay)
+				}
+				log.Warnf("Request failed with %s, retrying in %s. method=%q url=%q", resp.Status, delay, r.Method, r.URL)
+				io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+			} else {
+				log.Warnf("Request failed, retrying in %s. method=%q url=%q err=%q", delay, r.Method, r.URL, err)
+			}
+
+			select {
+			case <-r.Cancel:
+				return nil, fmt.Errorf("retry: canceled after %d attempt(s)", attempt+1)
+			case <-time.After(delay):
+			}
+		}
+	})
+}
+
+// Delay returns how long to wait before the next try after a failed attempt,
+// counting from zero.
+func (policy RetryPolicy) Delay(attempt int) time.Duration {
+	ceiling := policy.MaxDelay
+	if attempt < 30 && policy.MinDelay<<uint(attempt) < ceiling {
+		ceiling = policy.MinDelay << uint(attempt)
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+func (policy RetryPolicy) shouldRetry(r *http.Request, resp *http.Response, err error) bool {
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if !idempotentMethods[r.Method] {
+		return false
+	}
+	if resp == nil {
+		return err != nil
+	}
+	return transientStatusCodes[resp.StatusCode]
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"fmt"
+	"path"
 
 	log "github.com/Sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
@@ -28,6 +29,30 @@ func CreateSaveSsh(username, outputDirectory string) (privateKey *rsa.PrivateKey
 	return privateKey, publicKeyString, nil
 }
 
+func LoadOrCreateSaveSsh(username, outputDirectory string) (privateKey *rsa.PrivateKey, publicKeyString string, err error) {
+	privateKeyFilename := fmt.Sprintf("%s_rsa", username)
+	exists, err := DeploymentFileExists(outputDirectory, privateKeyFilename)
+	if err != nil {
+		return nil, "", err
+	}
+	if !exists {
+		return CreateSaveSsh(username, outputDirectory)
+	}
+
+	log.Infof("ssh: reusing existing private key. path=%q", path.Join(outputDirectory, privateKeyFilename))
+	privateKey, err = parseRsaPrivateKey(path.Join(outputDirectory, privateKeyFilename))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load existing private key for ssh: %q", err)
+	}
+
+	publicKeyString, err = sshPublicKeyString(privateKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return privateKey, publicKeyString, nil
+}
+
 func CreateSsh() (privateKey *rsa.PrivateKey, publicKeyString string, err error) {
 	log.Debugf("ssh: generating %dbit rsa key", SshKeySize)
 	privateKey, err = rsa.GenerateKey(rand.Reader, SshKeySize)
@@ -35,13 +60,21 @@ func CreateSsh() (privateKey *rsa.PrivateKey, publicKeyString string, err error)
 		return nil, "", fmt.Errorf("failed to generate private key for ssh: %q", err)
 	}
 
+	publicKeyString, err = sshPublicKeyString(privateKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return privateKey, publicKeyString, nil
+}
+
+func sshPublicKeyString(privateKey *rsa.PrivateKey) (string, error) {
 	publicKey := privateKey.PublicKey
 	sshPublicKey, err := ssh.NewPublicKey(&publicKey)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create openssh public key string: %q", err)
+		return "", fmt.Errorf("failed to create openssh public key string: %q", err)
 	}
 	authorizedKeyBytes := ssh.MarshalAuthorizedKey(sshPublicKey)
-	authorizedKey := string(authorizedKeyBytes)
 
-	return privateKey, authorizedKey, nil
+	return string(authorizedKeyBytes), nil
 }
@@ -0,0 +1,184 @@
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const (
+	TagDeployment = "azkube-deployment"
+	TagVersion    = "azkube-version"
+	TagCreated    = "azkube-created"
+	TagCreator    = "azkube-creator"
+	TagFlavor     = "azkube-flavor"
+	TagMasterFQDN = "azkube-master-fqdn"
+	TagNodeSize   = "azkube-node-size"
+	TagNodeCount  = "azkube-node-count"
+	TagNodePools  = "azkube-node-pools"
+	TagState      = "azkube-state"
+
+	// ClusterStateStopped and ClusterStateRunning are the values of
+	// TagState set by stop and start.
+	ClusterStateStopped = "stopped"
+	ClusterStateRunning = "running"
+)
+
+var (
+	// Version is set at build time with -ldflags "-X".
+	Version = "dev"
+)
+
+// ClusterTags returns the azkube metadata that deploy attaches to a
+// cluster's resource group and resources.
+func ClusterTags(manifest *DeploymentManifest, creator string) map[string]string {
+	tags := map[string]string{
+		TagDeployment: manifest.DeploymentName,
+		TagVersion:    Version,
+		TagCreated:    manifest.Created.UTC().Format(time.RFC3339),
+		TagCreator:    creator,
+		TagFlavor:     manifest.Flavor,
+		TagMasterFQDN: manifest.MasterFQDN,
+	}
+	for key, value := range NodePoolTags(manifest.NodePools) {
+		tags[key] = value
+	}
+	return tags
+}
+
+// NodePoolTags summarizes a cluster's node pools: the distinct node sizes,
+// the total node count and each pool as name:size:count.
+func NodePoolTags(pools []NodePool) map[string]string {
+	sizes := []string{}
+	summaries := []string{}
+	for _, pool := range pools {
+		if !containsString(sizes, pool.Size) {
+			sizes = append(sizes, pool.Size)
+		}
+		summaries = append(summaries, fmt.Sprintf("%s:%s:%d", pool.Name, pool.Size, pool.Count))
+	}
+
+	return map[string]string{
+		TagNodeSize:  strings.Join(sizes, ","),
+		TagNodeCount: strconv.Itoa(TotalNodeCount(pools)),
+		TagNodePools: strings.Join(summaries, ","),
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// TagsJSON renders the tags as a JSON object for the parameters template.
+func (flavorArgs FlavorArguments) TagsJSON() (string, error) {
+	return TagsJSON(flavorArgs.Tags)
+}
+
+// TagsJSON renders tags as a JSON object for a parameters template.
+func TagsJSON(tags map[string]string) (string, error) {
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	contents, err := json.Marshal(tags)
+	if err != nil {
+		return "", err
+	}
+	return string(contents), nil
+}
+
+func (azureClient *AzureClient) ResourceGroupTags(name string) (map[string]string, error) {
+	resourceGroup, err := azureClient.GroupsClient.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := map[string]string{}
+	if resourceGroup.Tags != nil {
+		for key, value := range *resourceGroup.Tags {
+			tags[key] = to.String(value)
+		}
+	}
+	return tags, nil
+}
+
+// TagResourceGroup merges tags into the tags already set on a resource group.
+func (azureClient *AzureClient) TagResourceGroup(name string, tags map[string]string) error {
+	resourceGroup, err := azureClient.GroupsClient.Get(name)
+	if err != nil {
+		return err
+	}
+
+	merged := map[string]*string{}
+	if resourceGroup.Tags != nil {
+		for key, value := range *resourceGroup.Tags {
+			merged[key] = value
+		}
+	}
+	for key, value := range tags {
+		merged[key] = to.StringPtr(value)
+	}
+
+	_, err = azureClient.GroupsClient.Patch(name, resources.ResourceGroup{
+		Location: resourceGroup.Location,
+		Tags:     &merged,
+	})
+	return err
+}
+
+// Creator identifies the signed in user or service principal, for tagging.
+func (azureClient *AzureClient) Creator() string {
+	accessToken, err := azureClient.armAccessToken()
+	if err == nil {
+		if user := tokenUser(accessToken); user != "" {
+			return user
+		}
+	}
+	return azureClient.ClientID
+}
+
+// armAccessToken returns the current resource manager access token, or an
+// empty string if the authorizer doesn't expose one.
+func (azureClient *AzureClient) armAccessToken() (string, error) {
+	switch token := azureClient.armAuthorizer.(type) {
+	case *azure.ServicePrincipalToken:
+		err := token.EnsureFresh()
+		if err != nil {
+			return "", err
+		}
+		return token.AccessToken, nil
+	case *MSIToken:
+		return token.AccessToken()
+	}
+	return "", nil
+}
+
+func tokenClaims(accessToken string) (map[string]interface{}, error) {
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("token: not a jwt")
+	}
+
+	payload, err := base64.URLEncoding.DecodeString(parts[1] + strings.Repeat("=", (4-len(parts[1])%4)%4))
+	if err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
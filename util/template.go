@@ -21,6 +21,7 @@ var (
 
 	variableRegex  = regexp.MustCompile(`\[\[\[([a-zA-Z]+)\]\]\]`)
 	parameterRegex = regexp.MustCompile(`\{\{\{([a-zA-Z]+)\}\}\}`)
+	nodePoolRegex  = regexp.MustCompile(`\(\(\(([a-zA-Z]+)\)\)\)`)
 )
 
 func b64(s string) string {
@@ -43,6 +44,8 @@ func InterpolateArmPlaceholders(flavor, scriptName string) (string, error) {
 
 	script = variableRegex.ReplaceAllString(script, `', variables('$1'), '`)
 	script = parameterRegex.ReplaceAllString(script, `', parameters('$1'), '`)
+	// only valid inside the node pool copy loop of the cluster template
+	script = nodePoolRegex.ReplaceAllString(script, `', parameters('nodePools')[copyIndex()].$1, '`)
 
 	script = `[base64(concat('` + script + `'))]`
 
@@ -12,10 +12,11 @@ import (
 // findTenantID figures out the AAD tenant ID of the subscription by making an
 // unauthenticated request to the Get Subscription Details endpoint and parses
 // the value from WWW-Authenticate header.
-func GetTenantID(env azure.Environment, subscriptionID string) (string, error) {
+func GetTenantID(env azure.Environment, subscriptionID string, retryPolicy RetryPolicy) (string, error) {
 	const hdrKey = "WWW-Authenticate"
 	c := subscriptions.NewClient()
 	c.BaseURI = env.ResourceManagerEndpoint
+	c.Sender = retryPolicy.Sender()
 
 	// we expect this request to fail (err != nil), but we are only interested
 	// in headers, so surface the error if the Response is not present (i.e.
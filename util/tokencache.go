@@ -0,0 +1,188 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	log "github.com/Sirupsen/logrus"
+	"github.com/mitchellh/go-homedir"
+)
+
+const (
+	tokenCachePrefix = "token-cache-"
+	tokenCacheSuffix = ".json"
+
+	// graphTokenCacheSuffix marks the cache of a service principal's graph
+	// token, kept next to its resource manager token.
+	graphTokenCacheSuffix = "-graph"
+
+	// tenant ids are guids
+	tenantIDLength = 36
+
+	// endpointHashLength is the length of the hash of the resource manager
+	// endpoint that tells apart the same service principal's caches in
+	// different Azure environments.
+	endpointHashLength = 8
+)
+
+// CachedToken describes a token cache file in the azkube directory. ClientID
+// is empty for device auth caches.
+type CachedToken struct {
+	Path      string
+	TenantID  string
+	ClientID  string
+	User      string
+	ExpiresOn time.Time
+}
+
+// TokenCacheDirectory returns the directory azkube caches tokens in.
+func TokenCacheDirectory() (string, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get user home directory to look for cached token: %q", err)
+	}
+	return filepath.Join(home, ".azkube"), nil
+}
+
+// DeviceTokenCachePath returns the path of the device auth token cache for a
+// tenant.
+func DeviceTokenCachePath(tenantID string) (string, error) {
+	directory, err := TokenCacheDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(directory, tokenCachePrefix+tenantID+tokenCacheSuffix), nil
+}
+
+// servicePrincipalTokenCachePath returns the path of a service principal's
+// resource manager token cache, or of its graph token cache, in the Azure
+// environment with the resource manager endpoint.
+//
+// The cached token is used until it expires without the service principal
+// authenticating again, so a secret that was rotated or mistyped is only
+// noticed then. `azkube auth logout` removes the cache.
+func servicePrincipalTokenCachePath(tenantID, clientID, resourceManagerEndpoint string, graph bool) (string, error) {
+	directory, err := TokenCacheDirectory()
+	if err != nil {
+		return "", err
+	}
+	name := tokenCachePrefix + tenantID + "-" + clientID + "-" + endpointHash(resourceManagerEndpoint)
+	if graph {
+		name += graphTokenCacheSuffix
+	}
+	return filepath.Join(directory, name+tokenCacheSuffix), nil
+}
+
+func endpointHash(endpoint string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSuffix(strings.ToLower(endpoint), "/")))
+	return hex.EncodeToString(sum[:])[:endpointHashLength]
+}
+
+// useCachedToken makes spt start from the token cached at cachePath, if it is
+// still valid, instead of getting a new one.
+func useCachedToken(spt *azure.ServicePrincipalToken, cachePath string) {
+	if _, err := os.Stat(cachePath); err != nil {
+		return
+	}
+	token, err := azure.LoadToken(cachePath)
+	if err != nil {
+		log.Warnf("Ignoring unreadable token cache. path=%q err=%q", cachePath, err)
+		return
+	}
+	if token.IsExpired() {
+		return
+	}
+	log.Debugf("Using cached token. path=%q", cachePath)
+	spt.Token = *token
+}
+
+// ListTokenCaches returns the resource manager token caches in the azkube
+// directory. Graph token caches are not listed separately.
+func ListTokenCaches() ([]CachedToken, error) {
+	directory, err := TokenCacheDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []CachedToken{}, nil
+		}
+		return nil, err
+	}
+
+	caches := []CachedToken{}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, tokenCachePrefix) || !strings.HasSuffix(name, tokenCacheSuffix) {
+			continue
+		}
+		key := strings.TrimSuffix(strings.TrimPrefix(name, tokenCachePrefix), tokenCacheSuffix)
+		if len(key) < tenantIDLength || strings.HasSuffix(key, graphTokenCacheSuffix) {
+			continue
+		}
+
+		cache := CachedToken{
+			Path:     filepath.Join(directory, name),
+			TenantID: key[:tenantIDLength],
+			ClientID: strings.TrimPrefix(key[tenantIDLength:], "-"),
+		}
+		if i := strings.LastIndex(cache.ClientID, "-"); i >= 0 && len(cache.ClientID)-i-1 == endpointHashLength {
+			cache.ClientID = cache.ClientID[:i]
+		}
+		token, err := azure.LoadToken(cache.Path)
+		if err != nil {
+			log.Warnf("Skipping unreadable token cache. path=%q err=%q", cache.Path, err)
+			continue
+		}
+		cache.ExpiresOn = token.Expires()
+		cache.User = tokenUser(token.AccessToken)
+		caches = append(caches, cache)
+	}
+	return caches, nil
+}
+
+// RemoveTokenCaches deletes the token caches of a tenant, or all of them if
+// tenantID is empty, and returns the paths it deleted.
+func RemoveTokenCaches(tenantID string) ([]string, error) {
+	directory, err := TokenCacheDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(directory, tokenCachePrefix+tenantID+"*"+tokenCacheSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf("Removed token cache. path=%q", path)
+	}
+	return paths, nil
+}
+
+// tokenUser returns the user or application an access token was issued to.
+func tokenUser(accessToken string) string {
+	claims, err := tokenClaims(accessToken)
+	if err != nil {
+		return ""
+	}
+	for _, claim := range []string{"upn", "unique_name", "email", "appid"} {
+		if value, ok := claims[claim].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -15,7 +16,7 @@ const (
 	validationAttempts = 20
 )
 
-func ValidateKubernetes(flavorArgs FlavorArguments) error {
+func ValidateKubernetes(flavorArgs FlavorArguments, cancel <-chan struct{}) error {
 	remainingRetries := validationAttempts
 	for {
 		remainingRetries--
@@ -23,6 +24,12 @@ func ValidateKubernetes(flavorArgs FlavorArguments) error {
 			break
 		}
 
+		select {
+		case <-cancel:
+			return fmt.Errorf("validate: canceled")
+		default:
+		}
+
 		log.Infof("Validating Kubernetes cluster.")
 
 		c, err := getClient(flavorArgs)
@@ -34,14 +41,14 @@ func ValidateKubernetes(flavorArgs FlavorArguments) error {
 		err = validateStatus(flavorArgs, c)
 		if err != nil {
 			log.Warnf("Failed to validate components: %s", err)
-			time.Sleep(validationDelay)
+			validationSleep(cancel)
 			continue
 		}
 
-		err = validateNodeCount(flavorArgs, c)
+		err = validateNodeCount(flavorArgs, c, "")
 		if err != nil {
 			log.Warnf("Failed to validate node count: %s", err)
-			time.Sleep(validationDelay)
+			validationSleep(cancel)
 			continue
 		}
 
@@ -51,13 +58,156 @@ func ValidateKubernetes(flavorArgs FlavorArguments) error {
 	return fmt.Errorf("Failed to validate cluster after %d tries.", validationAttempts)
 }
 
+// ValidateMaster waits for the apiserver to answer and report its components,
+// including etcd, as healthy. It does not look at the nodes.
+func ValidateMaster(flavorArgs FlavorArguments, cancel <-chan struct{}) error {
+	for attempt := 1; attempt < validationAttempts; attempt++ {
+		select {
+		case <-cancel:
+			return fmt.Errorf("validate: canceled")
+		default:
+		}
+
+		log.Infof("Waiting for the master to become healthy.")
+
+		c, err := getClient(flavorArgs)
+		if err != nil {
+			log.Warnf("Failed to get client for validation: %s", err)
+			validationSleep(cancel)
+			continue
+		}
+
+		err = validateStatus(flavorArgs, c)
+		if err != nil {
+			log.Warnf("Failed to validate components: %s", err)
+			validationSleep(cancel)
+			continue
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("Master did not become healthy after %d tries.", validationAttempts)
+}
+
+// ValidateNodeCount waits for the cluster to report flavorArgs.NodeCount
+// Ready nodes whose names start with nodeNamePrefix, after a node pool was
+// scaled. NotReady nodes with that prefix that are not in liveNodeNames
+// belong to instances that no longer exist, and are deleted along the way.
+func ValidateNodeCount(flavorArgs FlavorArguments, nodeNamePrefix string, liveNodeNames []string, cancel <-chan struct{}) error {
+	live := map[string]bool{}
+	for _, name := range liveNodeNames {
+		live[name] = true
+	}
+
+	for attempt := 1; attempt < validationAttempts; attempt++ {
+		select {
+		case <-cancel:
+			return fmt.Errorf("validate: canceled")
+		default:
+		}
+
+		log.Infof("Waiting for %d ready nodes.", flavorArgs.NodeCount)
+
+		c, err := getClient(flavorArgs)
+		if err != nil {
+			log.Warnf("Failed to get client for validation: %s", err)
+			validationSleep(cancel)
+			continue
+		}
+
+		err = removeStaleNodes(c, nodeNamePrefix, live)
+		if err != nil {
+			log.Warnf("Failed to remove stale nodes: %s", err)
+		}
+
+		err = validateNodeCount(flavorArgs, c, nodeNamePrefix)
+		if err != nil {
+			log.Warnf("Failed to validate node count: %s", err)
+			validationSleep(cancel)
+			continue
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("Failed to reach %d ready nodes after %d tries.", flavorArgs.NodeCount, validationAttempts)
+}
+
+// WaitForNodesReady waits until each of the named nodes reports Ready.
+func WaitForNodesReady(c *k8s.Client, nodeNames []string, cancel <-chan struct{}) error {
+	for attempt := 1; attempt < validationAttempts; attempt++ {
+		notReady := []string{}
+		for _, name := range nodeNames {
+			node, err := c.Nodes().Get(name)
+			if err != nil || !nodeReady(*node) {
+				notReady = append(notReady, name)
+			}
+		}
+		if len(notReady) == 0 {
+			return nil
+		}
+
+		log.Infof("Waiting for nodes to become ready: %v", notReady)
+		select {
+		case <-cancel:
+			return fmt.Errorf("validate: canceled")
+		case <-time.After(validationDelay):
+		}
+	}
+
+	return fmt.Errorf("Nodes did not become ready after %d tries.", validationAttempts)
+}
+
+func removeStaleNodes(c *k8s.Client, nodeNamePrefix string, live map[string]bool) error {
+	nodeList, err := c.Nodes().List(k8sapi.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodeList.Items {
+		if !strings.HasPrefix(node.Name, nodeNamePrefix) || live[node.Name] || nodeReady(node) {
+			continue
+		}
+		log.Infof("validate: removing stale node. node=%q", node.Name)
+		err = c.Nodes().Delete(node.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func nodeReady(node k8sapi.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == k8sapi.NodeReady {
+			return condition.Status == k8sapi.ConditionTrue
+		}
+	}
+	return false
+}
+
+func validationSleep(cancel <-chan struct{}) {
+	select {
+	case <-cancel:
+	case <-time.After(validationDelay):
+	}
+}
+
 func getClient(flavorArgs FlavorArguments) (*k8s.Client, error) {
+	return NewKubernetesClient(flavorArgs.MasterFQDN, flavorArgs.CAKeyPair, flavorArgs.ClientKeyPair)
+}
+
+// NewKubernetesClient connects to a cluster's apiserver with the client
+// certificate generated at deploy time.
+func NewKubernetesClient(masterFQDN string, ca, client *PkiKeyCertPair) (*k8s.Client, error) {
 	config := &restclient.Config{
-		Host: "https://" + flavorArgs.MasterFQDN + ":6443",
+		Host: "https://" + masterFQDN + ":6443",
 		TLSClientConfig: restclient.TLSClientConfig{
-			CAData:   []byte(flavorArgs.CAKeyPair.CertificatePem),
-			CertData: []byte(flavorArgs.ClientKeyPair.CertificatePem),
-			KeyData:  []byte(flavorArgs.ClientKeyPair.PrivateKeyPem),
+			CAData:   []byte(ca.CertificatePem),
+			CertData: []byte(client.CertificatePem),
+			KeyData:  []byte(client.PrivateKeyPem),
 		},
 	}
 
@@ -86,7 +236,10 @@ func validateStatus(flavorArgs FlavorArguments, c *k8s.Client) error {
 	return nil
 }
 
-func validateNodeCount(flavorArgs FlavorArguments, c *k8s.Client) error {
+// validateNodeCount counts the Ready nodes other than the master whose names
+// start with nodeNamePrefix, which may be empty to count all of them.
+// Cordoned nodes are counted too.
+func validateNodeCount(flavorArgs FlavorArguments, c *k8s.Client, nodeNamePrefix string) error {
 	log.Debugf("validate: counting nodes")
 
 	healthyNodeCount := 0
@@ -98,7 +251,12 @@ func validateNodeCount(flavorArgs FlavorArguments, c *k8s.Client) error {
 		return err
 	}
 
+	masterNodeName := MasterVMName(flavorArgs.DeploymentName)
 	for _, node := range nodeList.Items {
+		// the master isn't part of NodeCount
+		if strings.EqualFold(node.Name, masterNodeName) || !strings.HasPrefix(node.Name, nodeNamePrefix) {
+			continue
+		}
 		for _, condition := range node.Status.Conditions {
 			log.Debugf("validate: node (%q) type=%q status=%q message=%q reason=%q", node.Name, condition.Type, condition.Status, condition.Message, condition.Reason)
 			if condition.Type == k8sapi.NodeReady {
@@ -0,0 +1,134 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+	"github.com/Azure/go-autorest/autorest/to"
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	RenderedTemplateFilename   = "cluster-deploy.json"
+	RenderedParametersFilename = "cluster-parameters.json"
+)
+
+// TemplateValidationError is returned by ValidateTemplate when ARM rejects a
+// template and its parameters.
+type TemplateValidationError struct {
+	ResourceGroup  string
+	DeploymentName string
+	Details        []ArmErrorDetail
+}
+
+func (validationErr *TemplateValidationError) Error() string {
+	messages := []string{}
+	for _, detail := range validationErr.Details {
+		messages = append(messages, fmt.Sprintf("%s: %s", detail.Code, detail.Message))
+	}
+	return fmt.Sprintf("template validation failed: %s", strings.Join(messages, "; "))
+}
+
+// Report renders the validation errors as readable, multi-line text.
+func (validationErr *TemplateValidationError) Report() string {
+	lines := []string{
+		fmt.Sprintf("ARM template validation failed. resourceGroup=%q deployment=%q", validationErr.ResourceGroup, validationErr.DeploymentName),
+	}
+	for _, detail := range validationErr.Details {
+		line := fmt.Sprintf("  %s: %s", detail.Code, detail.Message)
+		if detail.Target != "" {
+			line = fmt.Sprintf("%s (target: %s)", line, detail.Target)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ValidateTemplate asks ARM to validate a template and its parameters
+// without creating anything. The resource group must already exist.
+func (azureClient *AzureClient) ValidateTemplate(resourceGroupName, deploymentName string, template map[string]interface{}, parameters map[string]interface{}) error {
+	deployment := resources.Deployment{
+		Properties: &resources.DeploymentProperties{
+			Template:   &template,
+			Parameters: &parameters,
+			Mode:       resources.Incremental,
+		},
+	}
+
+	log.Infof("Validating ARM template. resourceGroup=%q deployment=%q", resourceGroupName, deploymentName)
+	result, err := azureClient.DeploymentsClient.Validate(resourceGroupName, deploymentName, deployment)
+	if err != nil {
+		return err
+	}
+	if result.Error != nil {
+		validationErr := &TemplateValidationError{
+			ResourceGroup:  resourceGroupName,
+			DeploymentName: deploymentName,
+			Details:        flattenArmError(*result.Error),
+		}
+		for _, line := range strings.Split(validationErr.Report(), "\n") {
+			log.Error(line)
+		}
+		return validationErr
+	}
+	log.Infof("ARM template is valid. deployment=%q", deploymentName)
+
+	return nil
+}
+
+func (azureClient *AzureClient) ResourceGroupExists(name string) (bool, error) {
+	response, err := azureClient.GroupsClient.CheckExistence(name)
+	if err != nil {
+		return false, err
+	}
+	return response.StatusCode == http.StatusNoContent, nil
+}
+
+// LoadRenderedTemplate reads back the template and parameters written to an
+// output directory by RenderFlavor.
+func LoadRenderedTemplate(outputDirectory string) (template, parameters map[string]interface{}, err error) {
+	template, err = loadDeploymentMap(outputDirectory, RenderedTemplateFilename)
+	if err != nil {
+		return nil, nil, err
+	}
+	parameters, err = loadDeploymentMap(outputDirectory, RenderedParametersFilename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return template, parameters, nil
+}
+
+func loadDeploymentMap(directory, filename string) (map[string]interface{}, error) {
+	filePath := path.Join(directory, filename)
+
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("template: failed to read %q: %q", filePath, err)
+	}
+
+	var m map[string]interface{}
+	err = json.Unmarshal(contents, &m)
+	if err != nil {
+		return nil, fmt.Errorf("template: failed to parse %q: %q", filePath, err)
+	}
+	return m, nil
+}
+
+func flattenArmError(armErr resources.ResourceManagementErrorWithDetails) []ArmErrorDetail {
+	details := []ArmErrorDetail{{
+		Code:    to.String(armErr.Code),
+		Message: to.String(armErr.Message),
+		Target:  to.String(armErr.Target),
+	}}
+	if armErr.Details != nil {
+		for _, inner := range *armErr.Details {
+			details = append(details, flattenArmError(inner)...)
+		}
+	}
+	return details
+}